@@ -8,6 +8,7 @@ import (
 	"strings"
 	"taskd/dao"
 	"taskd/internal/flow"
+	"taskd/internal/task"
 	"taskd/internal/utils"
 	"taskd/service"
 
@@ -38,6 +39,9 @@ func respOK(c *gin.Context, data any) {
 func respError(c *gin.Context, code int, err error) {
 	utils.Errorf("request: %+v, error: %s", c.Request.RequestURI, err.Error())
 	if httpErr, ok := err.(*utils.HttpError); ok {
+		if retryAfter := httpErr.RetryAfter(); retryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+		}
 		c.JSON(httpErr.Code(), ResponseData{
 			Code:    strconv.Itoa(httpErr.Code()),
 			Message: httpErr.Error(),
@@ -249,7 +253,7 @@ func ListPools(c *gin.Context) {
 // @Description Get task pool details
 // @Tags TaskPools
 // @Param name path string true "Pool name"
-// @Param verbose query bool false "Get detailed info"
+// @Param verbose query bool false "Get detailed info, including queued/running tasks and resource usage"
 // @Accept json
 // @Produce json
 // @Success 200 {object} task.TaskPoolDetail "Pool information"
@@ -320,7 +324,7 @@ func DeletePool(c *gin.Context) {
 // UpdatePool
 // @Summary Update task pool
 // @Schemes
-// @Description Update task pool definition
+// @Description Update task pool definition, changes of running/waiting/config/engine/policy take effect without restart
 // @Tags TaskPools
 // @Param name path string true "Task pool ID"
 // @Param pools body service.TaskPoolArgs true "Task pool"
@@ -424,6 +428,94 @@ func TaskStop(c *gin.Context) {
 	respOK(c, "task stopped")
 }
 
+// TaskSuspend
+// @Summary Suspend task
+// @Schemes
+// @Description Suspend a queued or running task, its workload is torn down and its pool slot and quotas are released, the task record is kept until it is resumed
+// @Tags Tasks
+// @Param uuid path string true "Task UUID"
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Operation success message"
+// @Router /v1/tasks/{uuid}/suspend [POST]
+func TaskSuspend(c *gin.Context) {
+	if err := service.TaskSuspend(c.Param("uuid")); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	respOK(c, "task suspended")
+}
+
+// TaskResume
+// @Summary Resume task
+// @Schemes
+// @Description Resume a suspended task, it is queued again in its pool
+// @Tags Tasks
+// @Param uuid path string true "Task UUID"
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Operation success message"
+// @Router /v1/tasks/{uuid}/resume [POST]
+func TaskResume(c *gin.Context) {
+	if err := service.TaskResume(c.Param("uuid")); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	respOK(c, "task resumed")
+}
+
+// TaskMove
+// @Summary Move task to another pool
+// @Schemes
+// @Description Move a task that has not started to another pool with the same engine, a queued task keeps its queue time
+// @Tags Tasks
+// @Param uuid path string true "Task UUID"
+// @Param data body service.TaskMoveArgs true "Target pool"
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Operation success message"
+// @Router /v1/tasks/{uuid}/move [POST]
+func TaskMove(c *gin.Context) {
+	var req service.TaskMoveArgs
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respError(c, http.StatusBadRequest, err)
+		return
+	}
+	if err := service.TaskMove(c.Param("uuid"), &req); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	respOK(c, "task moved")
+}
+
+// TaskRerun
+// @Summary Rerun task
+// @Schemes
+// @Description Create a new task from a finished task, optionally overriding args, extra, pool and timeout
+// @Tags Tasks
+// @Param uuid path string true "Task UUID"
+// @Param req body service.TaskRerunArgs false "Overrides"
+// @Accept json
+// @Produce json
+// @Success 200 {object} service.TaskCommitResult "UUID of the new task"
+// @Router /v1/tasks/{uuid}/rerun [POST]
+func TaskRerun(c *gin.Context) {
+	var req service.TaskRerunArgs
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF { // Body is optional
+		respError(c, http.StatusBadRequest, err)
+		return
+	}
+	result, err := service.TaskRerun(c.Param("uuid"), &req)
+	if err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, result)
+}
+
 // TaskData
 // @Summary Get task metadata
 // @Schemes
@@ -444,6 +536,26 @@ func TaskData(c *gin.Context) {
 	respOK(c, to)
 }
 
+// TaskEvents
+// @Summary Get task status transitions
+// @Schemes
+// @Description Get status transitions of a task with time, reason and source, oldest first
+// @Tags Tasks
+// @Param uuid path string true "Task UUID"
+// @Accept json
+// @Produce json
+// @Success 200 {object} service.TaskEventsResult "Task status transitions"
+// @Router /v1/tasks/{uuid}/events [GET]
+func TaskEvents(c *gin.Context) {
+	result, err := service.TaskEvents(c.Param("uuid"))
+	if err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	respOK(c, result)
+}
+
 // TaskStatus
 // @Summary Get task status
 // @Schemes
@@ -463,6 +575,25 @@ func TaskStatus(c *gin.Context) {
 	respOK(c, result)
 }
 
+// TaskGraph
+// @Summary Get task graph
+// @Schemes
+// @Description Get the dependency graph (DAG) of the task, including all upstream and downstream tasks connected to it
+// @Tags Tasks
+// @Param uuid path string true "Task UUID"
+// @Accept json
+// @Produce json
+// @Success 200 {object} service.TaskGraphResult "Tasks of the graph with their upstream tasks"
+// @Router /v1/tasks/{uuid}/graph [GET]
+func TaskGraph(c *gin.Context) {
+	result, err := service.TaskGraph(c.Param("uuid"))
+	if err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, result)
+}
+
 // TaskLogs
 // @Summary Get task logs
 // @Description Get task logs with stream support (tail/follow) and regular pagination
@@ -547,3 +678,302 @@ func TaskGetTags(c *gin.Context) {
 	}
 	respOK(c, result)
 }
+
+// BatchCommit
+// @Summary Submit batch
+// @Schemes
+// @Description Submit a batch of tasks, listed one by one or expanded from one task with a matrix of args
+// @Tags Batches
+// @Param batch body service.BatchArgs true "Batch of tasks"
+// @Accept json
+// @Produce json
+// @Success 200 {object} service.BatchCommitResult "Batch ID and created tasks"
+// @Router /v1/batches [POST]
+func BatchCommit(c *gin.Context) {
+	var req service.BatchArgs
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respError(c, http.StatusBadRequest, err)
+		return
+	}
+	result, err := service.BatchCommit(&req)
+	if err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, result)
+}
+
+// BatchStatus
+// @Summary Get batch status
+// @Schemes
+// @Description Get number of tasks in each status of the batch
+// @Tags Batches
+// @Param id path string true "Batch ID"
+// @Accept json
+// @Produce json
+// @Success 200 {object} service.BatchStatusResult "Aggregated status of the batch"
+// @Router /v1/batches/{id} [GET]
+func BatchStatus(c *gin.Context) {
+	result, err := service.BatchStatus(c.Param("id"))
+	if err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, result)
+}
+
+// BatchCancel
+// @Summary Cancel batch
+// @Schemes
+// @Description Cancel all unfinished tasks of the batch
+// @Tags Batches
+// @Param id path string true "Batch ID"
+// @Accept json
+// @Produce json
+// @Success 200 {object} service.BatchCancelResult "Number of tasks cancelled"
+// @Router /v1/batches/{id} [DELETE]
+func BatchCancel(c *gin.Context) {
+	result, err := service.BatchCancel(c.Param("id"))
+	if err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, result)
+}
+
+// ListSchedules
+// @Summary List schedules
+// @Schemes
+// @Description List recurring task schedules
+// @Tags Schedules
+// @Accept json
+// @Produce json
+// @Success 200 {array} dao.ScheduleRec "Schedules"
+// @Router /v1/schedules [GET]
+func ListSchedules(c *gin.Context) {
+	schedules, err := dao.ListSchedules()
+	if err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, schedules)
+}
+
+// GetSchedule
+// @Summary Get schedule
+// @Schemes
+// @Description Get recurring task schedule
+// @Tags Schedules
+// @Param name path string true "Schedule name"
+// @Accept json
+// @Produce json
+// @Success 200 {object} dao.ScheduleRec "Schedule details"
+// @Router /v1/schedules/{name} [GET]
+func GetSchedule(c *gin.Context) {
+	s, err := dao.LoadSchedule(c.Param("name"))
+	if err != nil {
+		respError(c, http.StatusBadRequest, err)
+		return
+	}
+	respOK(c, s)
+}
+
+// AddSchedule
+// @Summary Create schedule
+// @Schemes
+// @Description Create recurring task schedule, the task is submitted each time the cron expression is due
+// @Tags Schedules
+// @Param schedule body dao.ScheduleRec true "Schedule definition"
+// @Accept json
+// @Produce json
+// @Success 200 {object} dao.ScheduleRec "Created schedule"
+// @Router /v1/schedules [POST]
+func AddSchedule(c *gin.Context) {
+	var req dao.ScheduleRec
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respError(c, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Name) > 64 {
+		respError(c, http.StatusBadRequest, fmt.Errorf("schedule name length cannot exceed 64 characters"))
+		return
+	}
+	if err := service.AddSchedule(&req); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, req)
+}
+
+// UpdateSchedule
+// @Summary Update schedule
+// @Schemes
+// @Description Update recurring task schedule, pause or resume it
+// @Tags Schedules
+// @Param name path string true "Schedule name"
+// @Param schedule body service.ScheduleUpdateArgs true "Fields to update"
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Update success message"
+// @Router /v1/schedules/{name} [PUT]
+func UpdateSchedule(c *gin.Context) {
+	var req service.ScheduleUpdateArgs
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respError(c, http.StatusBadRequest, err)
+		return
+	}
+	name := c.Param("name")
+	if err := service.UpdateSchedule(name, &req); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, fmt.Sprintf("schedule [%s] updated", name))
+}
+
+// DeleteSchedule
+// @Summary Delete schedule
+// @Schemes
+// @Description Delete recurring task schedule and its run history, submitted tasks are not affected
+// @Tags Schedules
+// @Param name path string true "Schedule name"
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Delete success message"
+// @Router /v1/schedules/{name} [DELETE]
+func DeleteSchedule(c *gin.Context) {
+	name := c.Param("name")
+	if err := service.DeleteSchedule(name); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, fmt.Sprintf("schedule [%s] deleted", name))
+}
+
+// ListScheduleRuns
+// @Summary List schedule runs
+// @Schemes
+// @Description List latest runs of the schedule with status of the submitted tasks, newest first
+// @Tags Schedules
+// @Param name path string true "Schedule name"
+// @Param req query service.ScheduleRunsArgs false "Query parameters"
+// @Accept json
+// @Produce json
+// @Success 200 {array} service.ScheduleRunResult "Run history"
+// @Router /v1/schedules/{name}/runs [GET]
+func ListScheduleRuns(c *gin.Context) {
+	var args service.ScheduleRunsArgs
+	if err := c.ShouldBindQuery(&args); err != nil {
+		respError(c, http.StatusBadRequest, err)
+		return
+	}
+	runs, err := service.ListScheduleRuns(c.Param("name"), &args)
+	if err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, runs)
+}
+
+// GetPoolLimits
+// @Summary Get concurrency limits of task pool
+// @Schemes
+// @Description Get concurrency limits per template, project or owner of the pool, with running tasks and waiting tasks blocked by each limit
+// @Tags TaskPools
+// @Param name path string true "Pool name"
+// @Accept json
+// @Produce json
+// @Success 200 {array} task.LimitUsage "Limits with their usage"
+// @Router /v1/pools/{name}/limits [GET]
+func GetPoolLimits(c *gin.Context) {
+	result, err := service.GetPoolLimits(c.Param("name"))
+	if err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, result)
+}
+
+// SetPoolLimits
+// @Summary Set concurrency limits of task pool
+// @Schemes
+// @Description Replace concurrency limits of the pool, tasks reaching a limit are skipped when picking the next task to run
+// @Tags TaskPools
+// @Param name path string true "Pool name"
+// @Param limits body []task.ConcurrencyLimit true "Concurrency limits"
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Update success message"
+// @Router /v1/pools/{name}/limits [PUT]
+func SetPoolLimits(c *gin.Context) {
+	var limits []task.ConcurrencyLimit
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		respError(c, http.StatusBadRequest, err)
+		return
+	}
+	name := c.Param("name")
+	if err := service.SetPoolLimits(name, limits); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, fmt.Sprintf("limits of pool [%s] updated", name))
+}
+
+// PausePool
+// @Summary Pause task pool
+// @Schemes
+// @Description Pause task pool, submissions are still queued up to the waiting limit but no task is started, running tasks are not affected
+// @Tags TaskPools
+// @Param name path string true "Pool name"
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Pause success message"
+// @Failure 400 {object} ResponseData "Pool not found"
+// @Router /v1/pools/{name}/pause [POST]
+func PausePool(c *gin.Context) {
+	poolId := c.Param("name")
+	if err := service.PausePool(poolId); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, fmt.Sprintf("pool [%s] paused", poolId))
+}
+
+// DrainPool
+// @Summary Drain task pool
+// @Schemes
+// @Description Drain task pool, new submissions are rejected while waiting and running tasks run to completion
+// @Tags TaskPools
+// @Param name path string true "Pool name"
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Drain success message"
+// @Failure 400 {object} ResponseData "Pool not found"
+// @Router /v1/pools/{name}/drain [POST]
+func DrainPool(c *gin.Context) {
+	poolId := c.Param("name")
+	if err := service.DrainPool(poolId); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, fmt.Sprintf("pool [%s] draining", poolId))
+}
+
+// ResumePool
+// @Summary Resume task pool
+// @Schemes
+// @Description Resume a paused or draining task pool, waiting tasks are started again
+// @Tags TaskPools
+// @Param name path string true "Pool name"
+// @Accept json
+// @Produce json
+// @Success 200 {string} string "Resume success message"
+// @Failure 400 {object} ResponseData "Pool not found"
+// @Router /v1/pools/{name}/resume [POST]
+func ResumePool(c *gin.Context) {
+	poolId := c.Param("name")
+	if err := service.ResumePool(poolId); err != nil {
+		respError(c, http.StatusInternalServerError, err)
+		return
+	}
+	respOK(c, fmt.Sprintf("pool [%s] resumed", poolId))
+}
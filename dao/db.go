@@ -70,5 +70,7 @@ func InitDB(dbc utils.DbConfig) error {
 	DB.AutoMigrate(&TemplateRec{})
 	DB.AutoMigrate(&Pool{})
 	DB.AutoMigrate(&PoolResource{})
+	DB.AutoMigrate(&ScheduleRec{})
+	DB.AutoMigrate(&ScheduleRunRec{})
 	return nil
 }
@@ -18,29 +18,78 @@ type TaskObjRec struct {
 	Project   string `json:"project,omitempty"`    // Project name
 	Template  string `json:"template,omitempty"`   // Template name
 	Pool      string `json:"pool,omitempty"`       // Task pool
+	Priority  int    `json:"priority,omitempty"`   // Priority in pool waiting queue, larger value runs first
 	Extra     string `json:"extra,omitempty"`      // Extra info for template (JSON)
 	Args      string `json:"args,omitempty"`       // User arguments for task (JSON)
 	Timeout   string `json:"timeout,omitempty"`    // Timeout settings (JSON)
+	Retry     string `json:"retry,omitempty"`      // Retry policy (JSON)
 	Quotas    string `json:"quotas,omitempty"`     // Resource quotas (JSON)
 	Tags      string `json:"tags,omitempty"`       // Tags affecting scheduling (JSON key=value)
 	Callback  string `json:"callback,omitempty"`   // Callback URL
 	CreatedBy string `json:"created_by,omitempty"` // Creator
+
+	DependsOn         []string `json:"depends_on,omitempty"`          // Upstream tasks that must succeed before this task is queued
+	OnUpstreamFailure string   `json:"on_upstream_failure,omitempty"` // Outcome when an upstream task does not succeed: cancel (default) or skip
+
+	StartAfter *time.Time `json:"start_after,omitempty"` // Task is not queued before this time
+	Delay      int        `json:"delay,omitempty"`       // Seconds to wait after submission before queuing, used if start_after is not set
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"` // Resubmission with the same key returns the unfinished task instead of creating one
+	Dedup          bool   `json:"dedup,omitempty"`           // Use hash of template and args as idempotency key if not specified
+
+	PoolSelector    string           `json:"pool_selector,omitempty"`    // Required pool labels when pool is not specified, e.g: gpu=a800,tier!=dev
+	PoolPreferences []PoolPreference `json:"pool_preferences,omitempty"` // Preferred pool labels, pools matching more weight are selected first
+
+	RerunOf string `json:"rerun_of,omitempty"` // The finished task this task is created from by rerun
+}
+
+/**
+ * Soft preference of pool labels
+ */
+type PoolPreference struct {
+	Selector string `json:"selector"` // Pool selector, e.g: region=sh
+	Weight   int    `json:"weight"`   // Weight added to pools matching the selector
 }
 
 /**
  * Task runtime records
  */
 type TaskRuntimeRec struct {
-	YamlContent string     `json:"yaml_content,omitempty"` // Deployment file content
-	CreateTime  *time.Time `json:"create_time"`            // Creation time
-	StartTime   *time.Time `json:"start_time"`             // Start time
-	RunningTime *time.Time `json:"running_time"`           // Running start time
-	EndTime     *time.Time `json:"end_time"`               // End time
-	UpdateTime  *time.Time `json:"update_time"`            // Last update time
-	Status      string     `json:"status"`                 // Task status
-	Error       string     `json:"error"`                  // Error message
-	Warning     string     `json:"warning"`                // Warning message
-	EndLog      string     `json:"end_log"`                // Final logs
+	YamlContent string      `json:"yaml_content,omitempty"` // Deployment file content
+	CreateTime  *time.Time  `json:"create_time"`            // Creation time
+	QueueTime   *time.Time  `json:"queue_time,omitempty"`   // Time of (re-)entering the waiting queue
+	StartTime   *time.Time  `json:"start_time"`             // Start time
+	RunningTime *time.Time  `json:"running_time"`           // Running start time
+	EndTime     *time.Time  `json:"end_time"`               // End time
+	UpdateTime  *time.Time  `json:"update_time"`            // Last update time
+	Status      string      `json:"status"`                 // Task status
+	Error       string      `json:"error"`                  // Error message
+	Warning     string      `json:"warning"`                // Warning message
+	EndLog      string      `json:"end_log"`                // Final logs
+	Attempts    []Attempt   `json:"attempts,omitempty"`     // Earlier attempts of a retried task
+	Requeues    int         `json:"requeues,omitempty"`     // Times re-queued after failing in Init phase
+	Events      []TaskEvent `json:"events,omitempty"`       // Status transitions, oldest first
+}
+
+/**
+ * A status transition of a task
+ */
+type TaskEvent struct {
+	From   string    `json:"from,omitempty"`   // Status before the transition, empty when submitted
+	To     string    `json:"to"`               // Status after the transition
+	Time   time.Time `json:"ts"`               // Time of the transition
+	Reason string    `json:"reason,omitempty"` // Why the status changed
+	Source string    `json:"source"`           // Who changed the status: user, scheduler, poller, reactor, engine, timeout or retry
+}
+
+/**
+ * A finished attempt of a retried task
+ */
+type Attempt struct {
+	StartTime *time.Time `json:"start_time,omitempty"` // Start time
+	EndTime   *time.Time `json:"end_time,omitempty"`   // End time
+	Status    string     `json:"status"`               // Final status
+	Error     string     `json:"error,omitempty"`      // Error message
 }
 
 /**
@@ -70,8 +119,11 @@ type ListTasksArgs struct {
 	Template  string `form:"template"`  // Template name
 	Project   string `form:"project"`   // Project name
 	Pool      string `form:"pool"`      // Target resource pool
+	Parent    string `form:"parent"`    // Batch ID
 	Owner     string `form:"owner"`     // Task owner
 	Status    string `form:"status"`    // Task status
+	RerunOf   string `form:"rerun_of"`  // Tasks rerun from this task
+	Chain     string `form:"chain"`     // Tasks in the rerun chain of this task: the original task and all reruns
 	Page      int    `form:"page"`      // Page number
 	PageSize  int    `form:"pageSize"`  // Items per page
 	Sort      string `form:"sort"`      // Sort field
@@ -101,6 +153,21 @@ type ListTasksResult struct {
 //                                     +--<status>:--+
 //                                                   +-<UUID> -> <status>
 //
+//                        +---depends_on:--+
+//                                         +--<upstream UUID>:--+
+//                                                              +-<UUID> -> <upstream UUID>
+//
+//                        +---parent:--+
+//                                     +--<batch>:---+
+//                                                   +-<UUID> -> <batch>
+//
+//                        +---rerun_of:--+
+//                                       +--<original UUID>:--+
+//                                                            +-<UUID> -> <original UUID>
+//
+//                        +---idempotency:--+
+//                                          +--<key> -> <UUID>
+//
 //          +--running:---+
 //                        +---<UUID> -> <UUID>
 //
@@ -108,6 +175,10 @@ type ListTasksResult struct {
 // When task initializes:
 //   1. Create indexes for namespace,name,project,template,pool,created_by under `tasks:indexes:`
 //      e.g. tasks:indexes:name:<name>:<UUID> means a task with name <name> and UUID <UUID>
+//      Dependent tasks are indexed by their upstream tasks under `tasks:indexes:depends_on:`
+//      Tasks of a batch are indexed by batch ID (parent) under `tasks:indexes:parent:`
+//      Reruns are indexed by the task they are created from under `tasks:indexes:rerun_of:`
+//      Idempotency key is mapped to the task last submitted with it under `tasks:indexes:idempotency:`
 //   2. Running task UUIDs are stored in `tasks:running:<UUID>` and removed when finished
 // When task finishes:
 //   1. Delete `tasks:running:<UUID>` key-value
@@ -171,6 +242,30 @@ func (ti *TaskRec) Create() error {
 		return err
 	}
 
+	// 7. Index by upstream tasks
+	for _, upstream := range ti.DependsOn {
+		dependKey := fmt.Sprintf("tasks:indexes:depends_on:%s:%s", upstream, ti.UUID)
+		if err := SetJSON(dependKey, upstream, 365*24*time.Hour); err != nil {
+			return err
+		}
+	}
+
+	// 8. Index by batch
+	if ti.Parent != "" {
+		parentKey := fmt.Sprintf("tasks:indexes:parent:%s:%s", ti.Parent, ti.UUID)
+		if err := SetJSON(parentKey, ti.Parent, 365*24*time.Hour); err != nil {
+			return err
+		}
+	}
+
+	// 9. Index by the task rerun from
+	if ti.RerunOf != "" {
+		rerunKey := fmt.Sprintf("tasks:indexes:rerun_of:%s:%s", ti.RerunOf, ti.UUID)
+		if err := SetJSON(rerunKey, ti.RerunOf, 365*24*time.Hour); err != nil {
+			return err
+		}
+	}
+
 	runningKey := fmt.Sprintf("tasks:running:%s", ti.UUID)
 	if err := SetJSON(runningKey, ti.UUID, 365*24*time.Hour); err != nil {
 		return err
@@ -186,9 +281,30 @@ func (ti *TaskRec) Update() error {
 }
 
 /**
- * Delete record
+ * Delete record with the indexes built by Create, e.g. a submission rejected before it is queued
  */
 func (ti *TaskRec) Delete() {
+	keys := []string{
+		fmt.Sprintf("tasks:indexes:name:%s:%s", ti.Name, ti.UUID),
+		fmt.Sprintf("tasks:indexes:namespace:%s:%s", ti.Namespace, ti.UUID),
+		fmt.Sprintf("tasks:indexes:project:%s:%s", ti.Project, ti.UUID),
+		fmt.Sprintf("tasks:indexes:template:%s:%s", ti.Template, ti.UUID),
+		fmt.Sprintf("tasks:indexes:pool:%s:%s", ti.Pool, ti.UUID),
+		fmt.Sprintf("tasks:indexes:created_by:%s:%s", ti.CreatedBy, ti.UUID),
+		fmt.Sprintf("tasks:running:%s", ti.UUID),
+	}
+	for _, upstream := range ti.DependsOn {
+		keys = append(keys, fmt.Sprintf("tasks:indexes:depends_on:%s:%s", upstream, ti.UUID))
+	}
+	if ti.Parent != "" {
+		keys = append(keys, fmt.Sprintf("tasks:indexes:parent:%s:%s", ti.Parent, ti.UUID))
+	}
+	if ti.RerunOf != "" {
+		keys = append(keys, fmt.Sprintf("tasks:indexes:rerun_of:%s:%s", ti.RerunOf, ti.UUID))
+	}
+	for _, key := range keys {
+		Del(key)
+	}
 	Del(ti.objKey())
 }
 
@@ -299,6 +415,18 @@ func (m *matcher) matchCondition(prefix, cond string) error {
 	return nil
 }
 
+/**
+ * Match tasks in the given list, e.g. found by following links between tasks
+ */
+func (m *matcher) matchKeys(keys []string) {
+	if !m.Activated {
+		m.Result = keys
+		m.Activated = true
+	} else {
+		m.Result = intersectionKeys(m.Result, keys)
+	}
+}
+
 /**
  * List tasks
  * When task finishes, move to Redis completed KV list as status won't change
@@ -314,9 +442,18 @@ func ListTasks(args *ListTasksArgs) (ListTasksResult, error) {
 	m.matchCondition("tasks:indexes:template", args.Template)
 	m.matchCondition("tasks:indexes:project", args.Project)
 	m.matchCondition("tasks:indexes:pool", args.Pool)
+	m.matchCondition("tasks:indexes:parent", args.Parent)
 	m.matchCondition("tasks:indexes:namespace", args.Namespace)
 	m.matchCondition("tasks:indexes:created_by", args.Owner)
 	m.matchCondition("tasks:indexes:status", args.Status)
+	m.matchCondition("tasks:indexes:rerun_of", args.RerunOf)
+	if args.Chain != "" {
+		chain, err := ListRerunChain(args.Chain)
+		if err != nil {
+			return taskResult, err
+		}
+		m.matchKeys(chain)
+	}
 
 	if m.Error != nil {
 		return taskResult, m.Error
@@ -369,6 +506,137 @@ func LoadTasks_NotFinished() ([]TaskRec, error) {
 	return tasks, nil
 }
 
+/**
+ * List UUIDs of tasks depending on the specified upstream task
+ */
+func ListDependents(uuid string) ([]string, error) {
+	keys, err := KeysByPrefix(fmt.Sprintf("tasks:indexes:depends_on:%s:*", uuid))
+	if err != nil {
+		return nil, err
+	}
+	return getUUIDs(keys), nil
+}
+
+/**
+ * List UUIDs of tasks rerun from the specified task
+ */
+func ListReruns(uuid string) ([]string, error) {
+	keys, err := KeysByPrefix(fmt.Sprintf("tasks:indexes:rerun_of:%s:*", uuid))
+	if err != nil {
+		return nil, err
+	}
+	return getUUIDs(keys), nil
+}
+
+/**
+ * List UUIDs of the rerun chain of a task, starting from the original task
+ * The chain goes back through rerun_of to the original task, then down to all tasks rerun from it
+ * and from its reruns, level by level
+ */
+func ListRerunChain(uuid string) ([]string, error) {
+	rec, err := LoadTask(uuid)
+	if err != nil {
+		return nil, err
+	}
+	if rec.UUID == "" { // Task is not exist
+		return []string{}, nil
+	}
+	visited := map[string]bool{rec.UUID: true}
+	for rec.RerunOf != "" && !visited[rec.RerunOf] {
+		original, err := LoadTask(rec.RerunOf)
+		if err != nil {
+			return nil, err
+		}
+		if original.UUID == "" { // The original task has expired
+			break
+		}
+		visited[original.UUID] = true
+		rec = original
+	}
+
+	chain := []string{rec.UUID}
+	visited = map[string]bool{rec.UUID: true}
+	for i := 0; i < len(chain); i++ {
+		reruns, err := ListReruns(chain[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, rerun := range reruns {
+			if !visited[rerun] {
+				visited[rerun] = true
+				chain = append(chain, rerun)
+			}
+		}
+	}
+	return chain, nil
+}
+
+// How long an idempotency key is held for a task still being submitted
+const idempotencyClaimTTL = time.Minute
+
+/**
+ * Get idempotency key index in Redis
+ */
+func idempotencyKey(key string) string {
+	return fmt.Sprintf("tasks:indexes:idempotency:%s", key)
+}
+
+/**
+ * Map idempotency key to the task if the key is not used yet
+ * The claim only lasts until the task is stored, KeepIdempotencyKey makes it last as long as the task
+ * Returns UUID of the task the key is mapped to, empty if claimed by this task
+ */
+func ClaimIdempotencyKey(key, uuid string) (string, error) {
+	for {
+		ok, err := SetJSONNX(idempotencyKey(key), uuid, idempotencyClaimTTL)
+		if err != nil || ok {
+			return "", err
+		}
+		var owner string
+		if err := GetJSON(idempotencyKey(key), &owner); err != nil {
+			return "", err
+		}
+		if owner != "" {
+			return owner, nil
+		}
+		// The key expired in between, claim it again
+	}
+}
+
+/**
+ * Keep the idempotency key claimed by the task once the task is stored
+ */
+func KeepIdempotencyKey(key, uuid string) error {
+	_, err := SwapJSON(idempotencyKey(key), uuid, uuid, 365*24*time.Hour)
+	return err
+}
+
+/**
+ * Map idempotency key to the task, replacing the former task only if the key is still mapped to it
+ * Returns false if another task took the key over in the meantime
+ */
+func ReplaceIdempotencyKey(key, former, uuid string) (bool, error) {
+	return SwapJSON(idempotencyKey(key), former, uuid, idempotencyClaimTTL)
+}
+
+/**
+ * Remove idempotency key
+ */
+func DelIdempotencyKey(key string) error {
+	return Del(idempotencyKey(key))
+}
+
+/**
+ * Load tasks of the batch
+ */
+func LoadBatchTasks(batch string) ([]TaskRec, error) {
+	keys, err := KeysByPrefix(fmt.Sprintf("tasks:indexes:parent:%s:*", batch))
+	if err != nil {
+		return nil, err
+	}
+	return getTasks(getUUIDs(keys)), nil
+}
+
 /**
  * Load task object from database
  */
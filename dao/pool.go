@@ -20,6 +20,9 @@ type Pool struct {
 	Config      string `gorm:"column:config;type:text" json:"config,omitempty"`                   //pool configuration for various task engines
 	Running     int    `gorm:"column:running;type:int" json:"running"`                            //maximum concurrent tasks
 	Waiting     int    `gorm:"column:waiting;type:int" json:"waiting"`                            //maximum queued tasks
+	Policy      string `gorm:"column:policy;type:text" json:"policy,omitempty"`                   //scheduling policy of the pool (JSON)
+	State       string `gorm:"column:state;type:varchar(30)" json:"state,omitempty"`              //pool state: active, paused or draining
+	Labels      string `gorm:"column:labels;type:varchar(1024)" json:"labels,omitempty"`          //pool labels matched by task pool selectors, e.g: gpu=a800,region=sh
 }
 
 /**
@@ -43,6 +46,21 @@ func (p *Pool) Update(tx *gorm.DB) error {
 	return tx.Updates(p).Error
 }
 
+/**
+ * Reverts Pool definition in database to p, including empty fields
+ * State is left alone, it is changed separately by UpdateState
+ */
+func (p *Pool) Revert(tx *gorm.DB) error {
+	return tx.Model(p).Select("engine", "description", "config", "running", "waiting", "policy", "labels").Updates(p).Error
+}
+
+/**
+ * Updates state of Pool in database, including empty state
+ */
+func (p *Pool) UpdateState(tx *gorm.DB) error {
+	return tx.Model(p).Where("pool_id = ?", p.PoolId).Update("state", p.State).Error
+}
+
 /**
  * Checks if Pool exists in database
  */
@@ -50,6 +50,40 @@ func GetJSON(key string, dest any) error {
 	return json.Unmarshal(data, dest)
 }
 
+// SetJSONNX Set JSON data if key does not exist, returns false if key exists
+func SetJSONNX(key string, value any, expiration time.Duration) (bool, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to marshal value")
+	}
+	return Client.SetNX(Ctx, key, data, expiration).Result()
+}
+
+// swapScript Replace the value only if it still equals the expected one
+var swapScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return false
+`)
+
+// SwapJSON Set JSON data if the key still holds old, returns false if it does not
+func SwapJSON(key string, old, value any, expiration time.Duration) (bool, error) {
+	oldData, err := json.Marshal(old)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to marshal old value")
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to marshal value")
+	}
+	err = swapScript.Run(Ctx, Client, []string{key}, oldData, data, expiration.Milliseconds()).Err()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 // Del Delete key
 func Del(key string) error {
 	return Client.Del(Ctx, key).Err()
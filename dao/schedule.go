@@ -0,0 +1,123 @@
+package dao
+
+import (
+	"fmt"
+	"time"
+)
+
+//------------------------------------------------------------------------------
+//	Schedule
+//------------------------------------------------------------------------------
+
+/**
+ *	Recurring task schedule
+ */
+type ScheduleRec struct {
+	Name        string     `gorm:"column:name;type:varchar(255);unique;comment:Schedule name" json:"name"`
+	Cron        string     `gorm:"column:cron;type:varchar(255);comment:Cron expression" json:"cron"`
+	Task        string     `gorm:"column:task;type:text;comment:Task object submitted on each run (JSON)" json:"task"`
+	Concurrency string     `gorm:"column:concurrency;type:varchar(30);comment:Concurrency policy: allow, forbid or replace" json:"concurrency,omitempty"`
+	Paused      bool       `gorm:"column:paused;comment:Schedule is paused" json:"paused"`
+	CreatedBy   string     `gorm:"column:created_by;type:varchar(255);comment:Creator" json:"created_by,omitempty"`
+	LastRunTime *time.Time `gorm:"column:last_run_time;comment:Last run time" json:"last_run_time,omitempty"`
+	NextRunTime *time.Time `gorm:"column:next_run_time;comment:Next run time" json:"next_run_time,omitempty"`
+	CreateTime  time.Time  `gorm:"column:create_time;autoCreateTime;comment:Create Time" json:"create_time,omitempty"`
+}
+
+/**
+ * Get database table name
+ * @return string Table name
+ */
+func (ScheduleRec) TableName() string {
+	return "schedule"
+}
+
+/**
+ * Store schedule record
+ */
+func (s *ScheduleRec) Store() error {
+	return DB.Create(s).Error
+}
+
+/**
+ * Update schedule record, including zero values
+ */
+func (s *ScheduleRec) Update() error {
+	return DB.Model(s).Where("name = ?", s.Name).Select("*").Updates(s).Error
+}
+
+/**
+ * Delete schedule record and its run history
+ */
+func (s *ScheduleRec) Delete() error {
+	if err := DB.Where("schedule = ?", s.Name).Delete(&ScheduleRunRec{}).Error; err != nil {
+		return err
+	}
+	return DB.Where("name = ?", s.Name).Delete(s).Error
+}
+
+/**
+ * Load schedule by name
+ */
+func LoadSchedule(name string) (*ScheduleRec, error) {
+	if name == "" {
+		return nil, fmt.Errorf("schedule name is empty")
+	}
+	var s ScheduleRec
+	err := DB.Model(&s).Where("name = ?", name).First(&s).Error
+	return &s, err
+}
+
+/**
+ * List all schedules
+ */
+func ListSchedules() ([]ScheduleRec, error) {
+	var schedules []ScheduleRec
+	if err := DB.Model(&ScheduleRec{}).Order("name").Find(&schedules).Error; err != nil {
+		return []ScheduleRec{}, err
+	}
+	return schedules, nil
+}
+
+//------------------------------------------------------------------------------
+//	ScheduleRun
+//------------------------------------------------------------------------------
+
+/**
+ *	A run of a schedule
+ */
+type ScheduleRunRec struct {
+	Id         int       `gorm:"primaryKey;autoIncrement;column:id" json:"id"`
+	Schedule   string    `gorm:"column:schedule;type:varchar(255);index;comment:Schedule name" json:"schedule"`
+	UUID       string    `gorm:"column:uuid;type:varchar(255);comment:Task submitted by the run" json:"uuid,omitempty"`
+	RunTime    time.Time `gorm:"column:run_time;comment:Scheduled time of the run" json:"run_time"`
+	Error      string    `gorm:"column:error;type:text;comment:Why no task was submitted" json:"error,omitempty"`
+	CreateTime time.Time `gorm:"column:create_time;autoCreateTime;comment:Create Time" json:"create_time,omitempty"`
+}
+
+/**
+ * Get database table name
+ * @return string Table name
+ */
+func (ScheduleRunRec) TableName() string {
+	return "schedule_run"
+}
+
+/**
+ * Store schedule run record
+ */
+func (r *ScheduleRunRec) Store() error {
+	return DB.Create(r).Error
+}
+
+/**
+ * List latest runs of the schedule, newest first
+ */
+func ListScheduleRuns(schedule string, limit int) ([]ScheduleRunRec, error) {
+	var runs []ScheduleRunRec
+	err := DB.Model(&ScheduleRunRec{}).Where("schedule = ?", schedule).Order("id desc").Limit(limit).Find(&runs).Error
+	if err != nil {
+		return []ScheduleRunRec{}, err
+	}
+	return runs, nil
+}
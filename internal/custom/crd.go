@@ -47,7 +47,7 @@ func NewCrd(td *dao.TemplateRec, tr *dao.TaskRec) (task.TaskJob, error) {
  *	Get client to communicate with K8S
  */
 func (s *Crd) getClientSet() *kubernetes.Clientset {
-	clientset, ok := s.GetPool().Extension.(*kubernetes.Clientset)
+	clientset, ok := s.GetPool().GetExtension().(*kubernetes.Clientset)
 	if !ok {
 		return nil
 	}
@@ -221,7 +221,7 @@ func (s *Crd) Logs(podName string, tail int64) ([]task.EntityLogs, error) {
 	results = append(results, task.EntityLogs{
 		Entity:    fmt.Sprintf("PyTorchJob %s/%s events", s.Namespace, s.Name),
 		Logs:      eventLog,
-		Completed: task.TaskStatus(s.Instance().Status).IsFinished(),
+		Completed: s.GetStatus().IsFinished(),
 	})
 	return results, nil
 }
@@ -70,6 +70,15 @@ func NewKFJob(td *dao.TemplateRec, tr *dao.TaskRec) (task.TaskJob, error) {
 	}
 
 	crd.replicas = task.GetArgInt(extra, "masterNum", 1) + task.GetArgInt(extra, "workerNum", 0)
+	// Gang scheduling can be enabled by template.extra or task_obj.extra, quotas are then per replica
+	merged, err := crd.GetExtra()
+	if err != nil {
+		return nil, fmt.Errorf("error in NewKFJob merge extra: %v", err)
+	}
+	if task.GetArgBool(merged, "gang", false) {
+		crd.replicas = task.GetArgNumber(extra, "masterNum", 1) + task.GetArgNumber(extra, "workerNum", 0)
+		crd.SetGang(crd.replicas)
+	}
 	crd.getLabel = utils.GetTaskLabelSelector
 	crd.ctx = context.Background()
 
@@ -80,7 +89,7 @@ func NewKFJob(td *dao.TemplateRec, tr *dao.TaskRec) (task.TaskJob, error) {
  * Get Kubernetes clientset
  */
 func (s *KFJob) getClientset() *kubernetes.Clientset {
-	clientset, ok := s.GetPool().Extension.(*kubernetes.Clientset)
+	clientset, ok := s.GetPool().GetExtension().(*kubernetes.Clientset)
 	if !ok {
 		return nil
 	}
@@ -284,7 +293,7 @@ func (s *KFJob) Logs(podName string, tail int64) ([]task.EntityLogs, error) {
 	results = append(results, task.EntityLogs{
 		Entity:    fmt.Sprintf("%s %s/%s events", s.crdKind, s.Namespace, s.Name),
 		Logs:      eventLog,
-		Completed: task.TaskStatus(s.Instance().Status).IsFinished(),
+		Completed: s.GetStatus().IsFinished(),
 	})
 
 	if len(podLogErrMsgs) > 0 {
@@ -56,7 +56,7 @@ func NewPod(td *dao.TemplateRec, tr *dao.TaskRec) (task.TaskJob, error) {
  * Get Kubernetes client
  */
 func (s *Pod) getClientset() *kubernetes.Clientset {
-	clientset, ok := s.GetPool().Extension.(*kubernetes.Clientset)
+	clientset, ok := s.GetPool().GetExtension().(*kubernetes.Clientset)
 	if !ok {
 		return nil
 	}
@@ -71,7 +71,7 @@ func (s *Pod) Start() error {
 		mus[s.Namespace].Unlock()
 	}()
 	mus[s.Namespace].Lock()
-	if ck := s.GetPool().Config; ck != "" {
+	if ck := s.GetPool().GetConfig(); ck != "" {
 		return utils.ApplyInference(s.Namespace, s.Template, s.YamlContent, ck)
 	}
 	return utils.Apply(s.Namespace, s.Template, s.YamlContent)
@@ -227,7 +227,7 @@ func (s *Pod) Stop() error {
 	mus[s.Namespace].Lock()
 
 	// Invoke synchronous kubectl delete command
-	if ck := s.GetPool().Config; ck != "" {
+	if ck := s.GetPool().GetConfig(); ck != "" {
 		return utils.DeleteSyncInference(s.Namespace, s.Template, s.YamlContent, ck)
 	}
 	return utils.DeleteSync(&utils.DestroyItem{
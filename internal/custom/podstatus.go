@@ -78,12 +78,11 @@ func (s PodStatusSet) earlier() PodStatus {
 
 /**
  *	Task status corresponding to POD set status
+ *	PODs exist only after the task has started, so a pending POD means the task is still initializing
  */
 func (s PodStatusSet) Status() task.TaskStatus {
 	switch s.earlier() {
-	case StatusPending:
-		return task.TaskStatusQueue
-	case StatusNotExist, StatusUnknown:
+	case StatusNotExist, StatusUnknown, StatusPending:
 		return task.TaskStatusInit
 	case StatusRunning:
 		return task.TaskStatusRunning
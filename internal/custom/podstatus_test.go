@@ -0,0 +1,31 @@
+package custom
+
+import (
+	"taskd/internal/task"
+	"testing"
+)
+
+func TestPodStatusSet_Status(t *testing.T) {
+	tests := []struct {
+		name string
+		pods map[string]PodStatus
+		want task.TaskStatus
+	}{
+		{"no pod", nil, task.TaskStatusInit},
+		{"pending", map[string]PodStatus{"master": StatusPending}, task.TaskStatusInit},
+		{"worker pending", map[string]PodStatus{"master": StatusRunning, "worker": StatusPending}, task.TaskStatusInit},
+		{"running", map[string]PodStatus{"master": StatusRunning, "worker": StatusRunning}, task.TaskStatusRunning},
+		{"failed", map[string]PodStatus{"master": StatusFailed, "worker": StatusSucceeded}, task.TaskStatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewPodStatusSet()
+			for name, status := range tt.pods {
+				s.Add(name, status)
+			}
+			if got := s.Status(); got != tt.want {
+				t.Errorf("Status() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
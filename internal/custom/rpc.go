@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 
 	"taskd/dao"
 	"taskd/internal/task"
@@ -13,17 +14,19 @@ import (
 
 // RPC task struct (using RESTful API requests)
 type Rpc struct {
-	ctx               context.Context   // Execution context
-	url               string            // Request URL
-	api               string            // API path
-	method            string            // HTTP method
-	headers           map[string]string // Request headers
-	paths             map[string]string // Path parameters
-	queries           map[string]string // Query parameters
-	body              string            // Request body
-	logs              []string          // Log messages
-	ss                *utils.Session    // Session connection to web service
-	task.TaskInstance                   // TaskInstance as a base class
+	ctx               context.Context    // Context of the current run, cancelled when the task is stopped
+	cancel            context.CancelFunc // Cancel the request of the current run
+	url               string             // Request URL
+	api               string             // API path
+	method            string             // HTTP method
+	headers           map[string]string  // Request headers
+	paths             map[string]string  // Path parameters
+	queries           map[string]string  // Query parameters
+	body              string             // Request body
+	logs              []string           // Log messages
+	logsMutex         sync.Mutex         // Guards logs appended by the request goroutine
+	ss                *utils.Session     // Session connection to web service
+	task.TaskInstance                    // TaskInstance as a base class
 }
 
 /*
@@ -61,8 +64,6 @@ func NewRpc(td *dao.TemplateRec, tr *dao.TaskRec) (task.TaskJob, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error in NewRpc parse args: %v", err)
 	}
-	rpc.ctx = context.Background()
-
 	rpc.url = task.GetArgString(extra, "url", "http://localhost:8080")
 	rpc.api = task.GetArgString(extra, "api", "")
 	rpc.method = task.GetArgString(extra, "method", "GET")
@@ -77,14 +78,25 @@ func NewRpc(td *dao.TemplateRec, tr *dao.TaskRec) (task.TaskJob, error) {
 
 /**
  * Start the task
+ * The request is sent with a context of this run, Stop() cancels it
  */
 func (s *Rpc) Start() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.ctx, s.cancel = ctx, cancel
 	s.SetStatus(task.TaskStatusInit)
 	s.Runner().OnJobStart(s)
 	go func() {
+		defer cancel()
+		if ctx.Err() != nil { // Stopped before the request is sent
+			return
+		}
 		s.SetStatus(task.TaskStatusRunning)
 		s.Runner().OnJobRunning(s)
-		_, err := s.ss.Request(s.method, s.api, s.paths, s.queries, s.headers, []byte(s.body))
+		_, err := s.ss.RequestContext(ctx, s.method, s.api, s.paths, s.queries, s.headers, []byte(s.body))
+		if ctx.Err() != nil { // Stopped by the system (timeout, preemption or suspension), which sets the status
+			s.appendLog(fmt.Sprintf("request cancelled: %v", err))
+			return
+		}
 		if err != nil {
 			s.SetError(task.TaskStatusFailed, err)
 		} else {
@@ -99,7 +111,7 @@ func (s *Rpc) Start() error {
  * Fetch/detect current task status (composite status from all PODs)
  */
 func (s *Rpc) FetchStatus() task.TaskStatus {
-	return task.TaskStatus(s.Status)
+	return s.GetStatus()
 }
 
 /**
@@ -110,7 +122,7 @@ func (s *Rpc) FollowLogs(podName string, timestamp bool, tail int64) (io.ReadClo
 
 	go func() {
 		defer pw.Close()
-		for _, log := range s.logs {
+		for _, log := range s.getLogs() {
 			_, err := fmt.Fprintln(pw, log)
 			if err != nil {
 				// Stop on write failure
@@ -129,16 +141,37 @@ func (s *Rpc) Logs(podName string, tail int64) ([]task.EntityLogs, error) {
 	var results []task.EntityLogs
 	var logs task.EntityLogs
 	logs.Entity = ""
-	logs.Logs = strings.Join(s.logs, "\n")
+	logs.Logs = strings.Join(s.getLogs(), "\n")
 	logs.Completed = (s.Phase() == task.PhaseFinished)
 	results = append(results, logs)
 	return results, nil
 }
 
 /**
- * Stop the task
+ * Append a log message
+ */
+func (s *Rpc) appendLog(log string) {
+	s.logsMutex.Lock()
+	defer s.logsMutex.Unlock()
+	s.logs = append(s.logs, log)
+}
+
+/**
+ * Get a copy of log messages
+ */
+func (s *Rpc) getLogs() []string {
+	s.logsMutex.Lock()
+	defer s.logsMutex.Unlock()
+	return append([]string(nil), s.logs...)
+}
+
+/**
+ * Stop the task, a request in flight is cancelled
  */
 func (s *Rpc) Stop() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
 	return nil
 }
 
@@ -0,0 +1,70 @@
+package custom
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"taskd/dao"
+	"taskd/internal/task"
+	"taskd/internal/utils"
+	"testing"
+	"time"
+)
+
+type recordRunner struct {
+	pool   *task.TaskPool
+	events chan string
+}
+
+func (r *recordRunner) Pool() *task.TaskPool          { return r.pool }
+func (r *recordRunner) OnJobStart(job task.TaskJob)   { r.events <- "start" }
+func (r *recordRunner) OnJobRunning(job task.TaskJob) { r.events <- "running" }
+func (r *recordRunner) OnJobEnd(job task.TaskJob)     { r.events <- "end" }
+func (r *recordRunner) Run()                          {}
+
+func TestRpc_StopCancelsRequest(t *testing.T) {
+	hung := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-hung:
+		}
+	}))
+	defer srv.Close()
+	defer close(hung)
+
+	tp := &task.TaskPool{}
+	tp.Init(&dao.Pool{PoolId: "rpc", Running: 1, Waiting: 1})
+	runner := &recordRunner{pool: tp, events: make(chan string, 3)}
+	tp.Runner = runner
+
+	rpc := &Rpc{method: http.MethodGet, api: "/hung", ss: utils.NewSession(srv.URL)}
+	rpc.UUID = "rpc"
+	rpc.Status = string(task.TaskStatusInit) // Started by the scheduler
+	rpc.AttachPool(tp)
+	if err := rpc.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	for _, want := range []string{"start", "running"} {
+		if got := <-runner.events; got != want {
+			t.Fatalf("event = %s, want %s", got, want)
+		}
+	}
+
+	// Stopped by the system, e.g. killed by timeout
+	rpc.SetError(task.TaskStatusKilled, errors.New("Running phase execution exceeded limit: 1m0s"))
+	if err := rpc.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	select {
+	case got := <-runner.events:
+		t.Fatalf("unexpected event %s after the request is cancelled", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if rpc.GetStatus() != task.TaskStatusKilled {
+		t.Errorf("status = %s, want Killed", rpc.GetStatus())
+	}
+	if logs := rpc.getLogs(); len(logs) != 1 {
+		t.Errorf("logs = %v, want the cancelled request", logs)
+	}
+}
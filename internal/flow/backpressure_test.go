@@ -0,0 +1,72 @@
+package flow
+
+import (
+	"net/http"
+	"taskd/dao"
+	"taskd/internal/task"
+	"taskd/internal/utils"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApplyBackpressure(t *testing.T) {
+	Convey("测试任务池等待队列已满时的处理", t, func() {
+		fill := func(tp *task.TaskPool) {
+			for i := 0; i < tp.Waiting; i++ {
+				tp.PushWaitingJob(&mockTaskJob{})
+			}
+		}
+		job := &mockTaskJob{}
+		job.UUID = "job"
+
+		Convey("默认拒绝并返回Retry-After", func() {
+			full := newMockPool(dao.Pool{PoolId: "full", Running: 1, Waiting: 1, Policy: `{"backpressure":{"mode":"reject","retry_after":60}}`})
+			fill(full)
+			allPools = map[string]*task.TaskPool{"full": full}
+			_, _, err := applyBackpressure(full, job, true)
+			httpErr, ok := err.(*utils.HttpError)
+			So(ok, ShouldBeTrue)
+			So(httpErr.Code(), ShouldEqual, http.StatusTooManyRequests)
+			So(httpErr.RetryAfter(), ShouldEqual, 60)
+		})
+
+		Convey("重载的任务不会被拒绝，放入积压队列", func() {
+			full := newMockPool(dao.Pool{PoolId: "full", Running: 1, Waiting: 1})
+			fill(full)
+			tp, backlog, err := applyBackpressure(full, job, false)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, full)
+			So(backlog, ShouldBeTrue)
+		})
+
+		Convey("溢出到其它任务池", func() {
+			full := newMockPool(dao.Pool{PoolId: "full", Running: 1, Waiting: 1, Policy: `{"backpressure":{"mode":"spill"}}`})
+			fill(full)
+			other := newMockPool(dao.Pool{PoolId: "other", Running: 1, Waiting: 5})
+			draining := newMockPool(dao.Pool{PoolId: "draining", Running: 1, Waiting: 10})
+			draining.SetState(task.PoolStateDraining)
+			allPools = map[string]*task.TaskPool{"full": full, "other": other, "draining": draining}
+			tp, backlog, err := applyBackpressure(full, job, true)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, other)
+			So(backlog, ShouldBeFalse)
+			So(job.Pool, ShouldEqual, "other")
+
+			Convey("没有可溢出的任务池时拒绝", func() {
+				fill(other)
+				_, _, err := applyBackpressure(full, job, true)
+				So(err, ShouldNotBeNil)
+			})
+		})
+
+		Convey("放入积压队列", func() {
+			full := newMockPool(dao.Pool{PoolId: "full", Running: 1, Waiting: 1, Policy: `{"backpressure":{"mode":"backlog"}}`})
+			fill(full)
+			tp, backlog, err := applyBackpressure(full, job, true)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, full)
+			So(backlog, ShouldBeTrue)
+		})
+	})
+}
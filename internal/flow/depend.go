@@ -0,0 +1,143 @@
+/**
+ * Dependency: tasks waiting for their upstream tasks
+ */
+package flow
+
+import (
+	"fmt"
+	"sync"
+	"taskd/dao"
+	"taskd/internal/task"
+	"taskd/internal/utils"
+)
+
+/**
+ * Tasks held in Pending state, indexed by task UUID
+ * Guarded by pendingJobsMutex, which also serializes checking upstream tasks
+ * against releasing dependents, so a finishing upstream task is never missed
+ */
+var pendingJobs = make(map[string]task.TaskJob)
+var pendingJobsMutex sync.Mutex
+
+/**
+ * Status of a task, unfinished tasks are looked up in memory first
+ * Returns final as false for tasks still in memory, an ended task may be
+ * requeued or retried until it is buried
+ */
+func taskStatus(uuid string) (status task.TaskStatus, final bool, err error) {
+	allJobsMutex.RLock()
+	job, ok := allJobs[uuid]
+	allJobsMutex.RUnlock()
+	if ok {
+		return job.Instance().GetStatus(), false, nil
+	}
+	tr, err := dao.LoadTask(uuid)
+	if err != nil {
+		return "", false, err
+	}
+	status = task.TaskStatus(tr.Status)
+	return status, status.IsFinished(), nil
+}
+
+/**
+ * Check upstream tasks of the job
+ * Returns unfinished upstream tasks, or an error if an upstream task did not succeed
+ */
+func checkUpstreams(ti *task.TaskInstance) ([]string, error) {
+	var waiting []string
+	for _, upstream := range ti.DependsOn {
+		status, final, err := taskStatus(upstream)
+		if err != nil {
+			return nil, fmt.Errorf("upstream task [%s] is not exist", upstream)
+		}
+		if !final {
+			waiting = append(waiting, upstream)
+		} else if status != task.TaskStatusSucceeded {
+			return nil, fmt.Errorf("upstream task [%s] ended with %s", upstream, status)
+		}
+	}
+	return waiting, nil
+}
+
+/**
+ * Hold the job in Pending state if its upstream tasks are not all succeeded
+ * Returns false if the job can enter the waiting queue now
+ */
+func holdPendingJob(job task.TaskJob) bool {
+	ti := job.Instance()
+	if len(ti.DependsOn) == 0 {
+		return false
+	}
+	pendingJobsMutex.Lock()
+	waiting, err := checkUpstreams(ti)
+	if err == nil && len(waiting) > 0 {
+		pendingJobs[ti.UUID] = job
+		ti.Pend(waiting)
+	}
+	pendingJobsMutex.Unlock()
+
+	if err != nil {
+		utils.Infof("Task [%s] ends as %s: %v", ti.Title(), ti.UpstreamFailureStatus(), err)
+		stopJob(job, ti.UpstreamFailureStatus(), err, task.SourceScheduler)
+		return true
+	}
+	if len(waiting) > 0 {
+		utils.Infof("Task [%s] is pending for upstream tasks %v", ti.Title(), waiting)
+		return true
+	}
+	if ti.GetStatus() == task.TaskStatusPending { // Reloaded after upstream tasks succeeded
+		ti.Requeue(task.TaskStatusQueue, "", task.SourceScheduler)
+	}
+	return false
+}
+
+/**
+ * Release or end the pending dependents of a finished job
+ * Dependents enter the waiting queue when all their upstream tasks succeeded,
+ * and end as Cancelled or Skipped when one of them did not succeed
+ */
+func resolveDependents(ti *task.TaskInstance) {
+	uuids, err := dao.ListDependents(ti.UUID)
+	if err != nil {
+		utils.Errorf("Task [%s] list dependents failed: %v", ti.Title(), err)
+		return
+	}
+	var released []task.TaskJob
+	var failed []task.TaskJob
+	var reasons []error
+
+	pendingJobsMutex.Lock()
+	delete(pendingJobs, ti.UUID)
+	for _, uuid := range uuids {
+		job, ok := pendingJobs[uuid]
+		if !ok {
+			continue
+		}
+		waiting, err := checkUpstreams(job.Instance())
+		if err != nil {
+			failed = append(failed, job)
+			reasons = append(reasons, err)
+		} else if len(waiting) == 0 {
+			released = append(released, job)
+		} else {
+			continue
+		}
+		delete(pendingJobs, uuid)
+	}
+	pendingJobsMutex.Unlock()
+
+	for _, job := range released {
+		dti := job.Instance()
+		if !dti.Requeue(task.TaskStatusQueue, "", task.SourceScheduler) {
+			utils.Infof("Task [%s] is not released, status %s", dti.Title(), dti.GetStatus())
+			continue
+		}
+		utils.Infof("Task [%s] upstream tasks succeeded", dti.Title())
+		enqueueJob(job)
+	}
+	for i, job := range failed {
+		dti := job.Instance()
+		utils.Infof("Task [%s] ends as %s: %v", dti.Title(), dti.UpstreamFailureStatus(), reasons[i])
+		stopJob(job, dti.UpstreamFailureStatus(), reasons[i], task.SourceScheduler)
+	}
+}
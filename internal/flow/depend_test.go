@@ -0,0 +1,160 @@
+package flow
+
+import (
+	"reflect"
+	"taskd/dao"
+	"taskd/internal/task"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDependentJobs(t *testing.T) {
+	Convey("测试任务依赖", t, func() {
+		tp := &task.TaskPool{}
+		upstream := &mockTaskJob{}
+		upstream.UUID = "upstream"
+		upstream.Status = string(task.TaskStatusRunning)
+		dependent := &mockTaskJob{}
+		dependent.UUID = "dependent"
+		dependent.Status = string(task.TaskStatusQueue)
+		dependent.DependsOn = []string{"upstream"}
+		dependent.AttachPool(tp)
+		// Goroutines left by former tests may still resolve dependents
+		allJobsMutex.Lock()
+		allJobs = map[string]task.TaskJob{"upstream": upstream, "dependent": dependent}
+		allJobsMutex.Unlock()
+		pendingJobsMutex.Lock()
+		pendingJobs = make(map[string]task.TaskJob)
+		pendingJobsMutex.Unlock()
+
+		var queued []task.TaskJob
+		var stopped task.TaskStatus
+		patches := gomonkey.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
+			return nil
+		})
+		patches.ApplyFunc(dao.ListDependents, func(uuid string) ([]string, error) {
+			return []string{"dependent"}, nil
+		})
+		patches.ApplyFunc(dao.LoadTask, func(uuid string) (*dao.TaskRec, error) {
+			return &upstream.TaskRec, nil
+		})
+		patches.ApplyMethod(reflect.TypeOf(tp), "SendWaitingChan", func(_ *task.TaskPool, job task.TaskJob) {
+			queued = append(queued, job)
+		})
+		patches.ApplyFunc(stopJob, func(job task.TaskJob, status task.TaskStatus, err error, source string) {
+			stopped = status
+		})
+		defer patches.Reset()
+
+		So(holdPendingJob(dependent), ShouldBeTrue)
+		So(dependent.GetStatus(), ShouldEqual, task.TaskStatusPending)
+		So(pendingJobs, ShouldContainKey, "dependent")
+
+		// Upstream task is buried and leaves memory before its dependents are resolved
+		bury := func(status task.TaskStatus) {
+			upstream.Status = string(status)
+			allJobsMutex.Lock()
+			delete(allJobs, "upstream")
+			allJobsMutex.Unlock()
+		}
+
+		Convey("上游任务成功后进入等待队列", func() {
+			bury(task.TaskStatusSucceeded)
+			resolveDependents(&upstream.TaskInstance)
+			So(queued, ShouldHaveLength, 1)
+			So(dependent.GetStatus(), ShouldEqual, task.TaskStatusQueue)
+			So(pendingJobs, ShouldBeEmpty)
+		})
+
+		Convey("上游任务失败后按配置跳过", func() {
+			dependent.OnUpstreamFailure = task.UpstreamFailureSkip
+			bury(task.TaskStatusFailed)
+			resolveDependents(&upstream.TaskInstance)
+			So(queued, ShouldBeEmpty)
+			So(stopped, ShouldEqual, task.TaskStatusSkipped)
+			So(pendingJobs, ShouldBeEmpty)
+		})
+
+		Convey("上游任务仍在运行时保持等待", func() {
+			resolveDependents(&upstream.TaskInstance)
+			So(queued, ShouldBeEmpty)
+			So(pendingJobs, ShouldContainKey, "dependent")
+		})
+
+		Convey("上游任务失败但尚未埋葬时保持等待", func() {
+			// Failed upstream task may still be requeued or retried
+			upstream.Status = string(task.TaskStatusFailed)
+			resolveDependents(&upstream.TaskInstance)
+			So(queued, ShouldBeEmpty)
+			So(stopped, ShouldBeEmpty)
+			So(pendingJobs, ShouldContainKey, "dependent")
+		})
+
+		Convey("等待中被取消的任务不再进入等待队列", func() {
+			So(dependent.Finish(task.TaskStatusCancelled, nil, task.SourceUser), ShouldBeTrue)
+			bury(task.TaskStatusSucceeded)
+			resolveDependents(&upstream.TaskInstance)
+			So(queued, ShouldBeEmpty)
+			So(dependent.GetStatus(), ShouldEqual, task.TaskStatusCancelled)
+			So(pendingJobs, ShouldBeEmpty)
+		})
+	})
+}
+
+func TestEnqueueScheduledJob(t *testing.T) {
+	Convey("测试延迟启动任务", t, func() {
+		tp := &task.TaskPool{}
+		job := &mockTaskJob{}
+		job.UUID = "scheduled"
+		job.Status = string(task.TaskStatusQueue)
+		job.AttachPool(tp)
+
+		queued := make(chan task.TaskJob, 1)
+		// The start_after timer is fired by the test itself, so nothing runs concurrently with the assertions
+		timers := make(chan func(), 1)
+		patches := gomonkey.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
+			return nil
+		})
+		patches.ApplyMethod(reflect.TypeOf(tp), "SendWaitingChan", func(_ *task.TaskPool, job task.TaskJob) {
+			queued <- job
+		})
+		patches.ApplyFunc(time.AfterFunc, func(d time.Duration, f func()) *time.Timer {
+			timers <- f
+			return nil
+		})
+		defer patches.Reset()
+
+		Convey("到达start_after后进入等待队列", func() {
+			startAfter := time.Now().Add(time.Minute)
+			job.StartAfter = &startAfter
+			enqueueJob(job)
+			So(job.GetStatus(), ShouldEqual, task.TaskStatusScheduled)
+			So(queued, ShouldBeEmpty)
+
+			fired := time.Now()
+			(<-timers)()
+			So(<-queued, ShouldEqual, job)
+			So(job.GetStatus(), ShouldEqual, task.TaskStatusQueue)
+			So(job.QueueTime.Before(fired), ShouldBeFalse)
+		})
+
+		Convey("取消后不再进入等待队列", func() {
+			startAfter := time.Now().Add(time.Minute)
+			job.StartAfter = &startAfter
+			enqueueJob(job)
+			So(job.Finish(task.TaskStatusCancelled, nil, task.SourceUser), ShouldBeTrue)
+			(<-timers)()
+			So(queued, ShouldBeEmpty)
+		})
+
+		Convey("start_after已过时直接进入等待队列", func() {
+			startAfter := time.Now().Add(-time.Minute)
+			job.StartAfter = &startAfter
+			enqueueJob(job)
+			So(<-queued, ShouldEqual, job)
+		})
+	})
+}
@@ -46,18 +46,74 @@ func Init() error {
  * Reload pool configurations
  */
 func ReloadPoolConfigs(poolId string) error {
-	for _, tp := range allPools {
-		if poolId != tp.PoolId {
-			continue
-		}
-		if err := tp.ReloadResources(); err != nil {
-			utils.Errorf("Pool [%s] ReloadResources failed: %s", tp.PoolId, err.Error())
-			return err
-		}
+	tp := GetPool(poolId)
+	if tp == nil {
+		return nil
+	}
+	if err := tp.ReloadResources(); err != nil {
+		utils.Errorf("Pool [%s] ReloadResources failed: %s", tp.PoolId, err.Error())
+		return err
+	}
+	return nil
+}
+
+/**
+ * Apply an updated pool definition to the live pool
+ * Raising Running starts waiting jobs at once, lowering it lets running jobs finish
+ * while no new job starts until the running count drops below the new limit
+ */
+func ReconfigurePool(pool dao.Pool) error {
+	tp := GetPool(pool.PoolId)
+	if tp == nil {
+		return initPool(pool)
+	}
+	if pool.Engine != tp.Engine {
+		return replacePool(tp, pool)
+	}
+	if err := tp.Reconfigure(&pool); err != nil {
+		return err
+	}
+	if err := ReloadPoolConfigs(pool.PoolId); err != nil {
+		return err
+	}
+	utils.Infof("Pool [%s] reconfigured: running=%d, waiting=%d", pool.PoolId, pool.Running, pool.Waiting)
+	tp.SendRunningChan(1)
+	return nil
+}
+
+/**
+ * Replace a pool with a new one using another engine
+ * Jobs hold the pool they are attached to, so the pool must have no unfinished job
+ */
+func replacePool(tp *task.TaskPool, pool dao.Pool) error {
+	if count := countPoolJobs(tp); count > 0 {
+		return fmt.Errorf("pool [%s] has %d unfinished tasks, engine cannot be changed", pool.PoolId, count)
+	}
+	pool.State = tp.GetState()
+	if err := initPool(pool); err != nil {
+		return err
 	}
+	tp.Close()
+	utils.Infof("Pool [%s] engine changed from [%s] to [%s]", pool.PoolId, tp.Engine, pool.Engine)
 	return nil
 }
 
+/**
+ * Count unfinished jobs attached to the pool, including jobs out of its queues
+ * such as pending, scheduled, suspended or backing off jobs
+ */
+func countPoolJobs(tp *task.TaskPool) int {
+	allJobsMutex.RLock()
+	defer allJobsMutex.RUnlock()
+	count := 0
+	for _, job := range allJobs {
+		if job.Instance().GetPool() == tp {
+			count++
+		}
+	}
+	return count
+}
+
 /**
  * Reload unfinished task instances
  */
@@ -122,8 +178,15 @@ func initPool(pool dao.Pool) error {
  * Get information for all task pools
  */
 func ListPools() []task.TaskPoolSummary {
+	allPoolsMutex.RLock()
+	tps := make([]*task.TaskPool, 0, len(allPools))
+	for _, tp := range allPools {
+		tps = append(tps, tp)
+	}
+	allPoolsMutex.RUnlock()
+
 	var pools []task.TaskPoolSummary
-	for _, p := range allPools {
+	for _, p := range tps {
 		pools = append(pools, p.GetSummary())
 	}
 	sort.Slice(pools, func(i, j int) bool {
@@ -153,12 +216,13 @@ func RemovePool(poolId string) error {
 		return nil
 	}
 
-	// Check if there are running tasks
-	if running := tp.GetRunningCount() + tp.GetWaitingCount(); running > 0 {
-		return fmt.Errorf("pool [%s] has %d tasks", poolId, running)
+	// Check if there are unfinished tasks, queued or not
+	if count := countPoolJobs(tp); count > 0 {
+		return fmt.Errorf("pool [%s] has %d tasks", poolId, count)
 	}
 
 	delete(allPools, poolId)
+	tp.Close()
 	return nil
 }
 
@@ -178,24 +242,78 @@ func selectPool(job task.TaskJob) (*task.TaskPool, error) {
 		}
 		return tp, nil
 	}
-	var selected *task.TaskPool = nil
-	var maxWaiting int = 0
+	selector, err := ti.GetPoolSelector()
+	if err != nil {
+		return nil, err
+	}
+	quotas := ti.GetQuotas()
+	var selected *poolScore = nil
 	for _, tp := range allPools {
-		if task.TaskEngineKind(tp.Engine) == job.Engine() {
-			waiting, _ := tp.GetCapacity()
-			if waiting > maxWaiting {
-				maxWaiting = waiting
-				selected = tp
-			}
+		if task.TaskEngineKind(tp.Engine) != job.Engine() || !tp.IsAccepting() {
+			continue
+		}
+		labels := tp.GetLabels()
+		if !selector.Matches(labels) {
+			continue
+		}
+		waiting, running := tp.GetCapacity()
+		score := &poolScore{
+			pool:       tp,
+			hasRoom:    !tp.IsFull(),
+			preference: ti.PreferenceScore(labels),
+			running:    running,
+			resource:   tp.FreeResourceRatio(quotas),
+			waiting:    waiting,
+		}
+		if selected == nil || score.better(selected) {
+			selected = score
 		}
 	}
 	if selected != nil {
-		return selected, nil
+		return selected.pool, nil
+	}
+	if len(selector) > 0 {
+		return nil, fmt.Errorf("there is no pool matching selector [%s] to run the [%s] engine required by task [%s]",
+			selector, job.Engine(), ti.Title())
 	}
 	return nil, fmt.Errorf("there is no pool available to run the [%s] engine required by task [%s]",
 		job.Engine(), ti.Title())
 }
 
+/**
+ * Score of a candidate pool for a task
+ */
+type poolScore struct {
+	pool       *task.TaskPool
+	hasRoom    bool    // Waiting queue is not full
+	preference int     // Weight of matched task preferences
+	running    int     // Free running slots
+	resource   float64 // Fraction of resources left after the task quotas are taken
+	waiting    int     // Free waiting slots
+}
+
+/**
+ * Compare scores in order of importance, pool ID breaks ties so selection is stable
+ */
+func (s *poolScore) better(o *poolScore) bool {
+	if s.hasRoom != o.hasRoom {
+		return s.hasRoom
+	}
+	if s.preference != o.preference {
+		return s.preference > o.preference
+	}
+	if s.running != o.running {
+		return s.running > o.running
+	}
+	if s.resource != o.resource {
+		return s.resource > o.resource
+	}
+	if s.waiting != o.waiting {
+		return s.waiting > o.waiting
+	}
+	return s.pool.PoolId < o.pool.PoolId
+}
+
 /**
  * Find/reload TaskJob by instance ID
  */
@@ -211,8 +329,24 @@ func GetJob(uuid string) (task.TaskJob, error) {
 
 /**
  * Create a new job and add it to the pool for execution
+ * Used for reloaded jobs, which are already accepted and never rejected by backpressure
  */
 func PoolNewJob(tr *dao.TaskRec) (task.TaskJob, error) {
+	return newJob(tr, false)
+}
+
+/**
+ * Create a job for a newly submitted task and add it to the pool for execution
+ * If the waiting queue of the pool is full, the backpressure policy of the pool applies
+ */
+func SubmitJob(tr *dao.TaskRec) (task.TaskJob, error) {
+	return newJob(tr, true)
+}
+
+/**
+ * Create a job and add it to the pool for execution
+ */
+func newJob(tr *dao.TaskRec, submit bool) (task.TaskJob, error) {
 	job, err := task.CreateJob(tr)
 	if err != nil {
 		return nil, err
@@ -221,16 +355,92 @@ func PoolNewJob(tr *dao.TaskRec) (task.TaskJob, error) {
 	if err != nil {
 		return nil, err
 	}
+	backlog := false
+	if tp.IsFull() {
+		if tp, backlog, err = applyBackpressure(tp, job, submit); err != nil {
+			return nil, err
+		}
+	}
 	job.Instance().AttachPool(tp)
 
 	allJobsMutex.Lock()
 	allJobs[tr.UUID] = job
 	allJobsMutex.Unlock()
 
-	tp.SendWaitingChan(job)
+	if holdPendingJob(job) {
+		return job, nil
+	}
+	if job.Instance().GetStatus() == task.TaskStatusSuspended { // Reloaded suspended job waits to be resumed
+		return job, nil
+	}
+	if backlog {
+		tp.PushBacklogJob(job)
+		return job, nil
+	}
+	enqueueJob(job)
 	return job, err
 }
 
+/**
+ * Decide where a job goes when the waiting queue of its pool is full
+ * Returns the pool to use and whether the job should be parked in its backlog
+ */
+func applyBackpressure(tp *task.TaskPool, job task.TaskJob, submit bool) (*task.TaskPool, bool, error) {
+	bp := tp.GetBackpressure()
+	if bp.Mode == task.BackpressureBacklog {
+		return tp, true, nil
+	}
+	// Reloaded jobs are never rejected, they are parked until the waiting queue has room
+	if !submit {
+		return tp, true, nil
+	}
+	if bp.Mode == task.BackpressureSpill {
+		if target := selectSpillPool(tp, job, bp.SpillTo); target != nil {
+			ti := job.Instance()
+			utils.Infof("Task [%s] spilled from pool [%s] to [%s]", ti.Title(), tp.PoolId, target.PoolId)
+			ti.Pool = target.PoolId
+			ti.SetWarning(fmt.Sprintf("spilled from pool [%s] whose waiting queue is full", tp.PoolId))
+			return target, false, nil
+		}
+	}
+	return nil, false, utils.NewHttpError(http.StatusTooManyRequests,
+		fmt.Sprintf("waiting queue of pool [%s] is full", tp.PoolId)).WithRetryAfter(bp.RetryAfter)
+}
+
+/**
+ * Select a pool with room in its waiting queue to take a job spilled from a full pool
+ * Pools listed in spillTo are tried in order, otherwise the pool with the most room
+ * among pools with the same engine
+ */
+func selectSpillPool(from *task.TaskPool, job task.TaskJob, spillTo []string) *task.TaskPool {
+	allPoolsMutex.RLock()
+	defer allPoolsMutex.RUnlock()
+
+	usable := func(tp *task.TaskPool) bool {
+		return tp != from && task.TaskEngineKind(tp.Engine) == job.Engine() && tp.IsAccepting() && !tp.IsFull()
+	}
+	if len(spillTo) > 0 {
+		for _, poolId := range spillTo {
+			if tp, ok := allPools[poolId]; ok && usable(tp) {
+				return tp
+			}
+		}
+		return nil
+	}
+	var selected *task.TaskPool
+	maxWaiting := 0
+	for _, tp := range allPools {
+		if !usable(tp) {
+			continue
+		}
+		if waiting, _ := tp.GetCapacity(); waiting > maxWaiting {
+			maxWaiting = waiting
+			selected = tp
+		}
+	}
+	return selected
+}
+
 /**
  * Cancel a task
  */
@@ -246,7 +456,7 @@ func CancelJob(uuid string) error {
 		utils.Infof("Task [%s] has ended early", uuid)
 		return nil
 	}
-	stopJob(job, task.TaskStatusCancelled, fmt.Errorf("user cancelled"))
+	stopJob(job, task.TaskStatusCancelled, fmt.Errorf("user cancelled"), task.SourceUser)
 	return nil
 }
 
@@ -255,9 +465,12 @@ func CancelJob(uuid string) error {
  */
 func startJob(job task.TaskJob) error {
 	ti := job.Instance()
-	ti.Prerun()
+	if !ti.Prerun() {
+		ti.FreeQuotas()
+		return fmt.Errorf("task [%s] cannot start from %s", ti.Title(), ti.GetStatus())
+	}
 	if err := job.Start(); err != nil {
-		stopJob(job, task.TaskStatusFailed, err)
+		stopJob(job, task.TaskStatusFailed, err, task.SourceScheduler)
 		return fmt.Errorf("task [%s] start failed: %v", ti.Title(), err)
 	}
 	ti.GetPool().AddRunningJob(job)
@@ -265,35 +478,77 @@ func startJob(job task.TaskJob) error {
 }
 
 /**
- * Request task instance to stop
+ * Request task instance to stop, the transition is recorded as made by source
+ * Nothing is done if the state machine refuses it, the job has been finished by someone else then
  */
-func stopJob(job task.TaskJob, status task.TaskStatus, err error) {
+func stopJob(job task.TaskJob, status task.TaskStatus, err error, source string) {
 	if !status.IsFinished() {
 		panic(fmt.Errorf("status [%s] is not completed", status))
 	}
 	ti := job.Instance()
-	if err != nil {
-		ti.SetError(status, err)
-	} else {
-		ti.SetStatus(status)
+	if !ti.Finish(status, err, source) {
+		return
 	}
 	ti.GetPool().SendFinishedChan(job)
 }
 
+/**
+ * Preempt a lower priority running job of the pool to make room for job
+ * The preempted job is stopped, marked as Preempted and put back to the waiting queue
+ */
+func preemptFor(tp *task.TaskPool, job task.TaskJob) bool {
+	victim := tp.SelectPreemptee(job.Instance().Priority)
+	if victim == nil {
+		return false
+	}
+	ti := victim.Instance()
+	utils.Infof("Task [%s] is preempted by task [%s]", ti.Title(), job.Instance().Title())
+	// Leave the running table first, so the runner stops watching the job being torn down
+	tp.RemoveRunningJob(victim)
+	if err := victim.Stop(); err != nil {
+		utils.Errorf("Task [%s] stop failed: %s", ti.Title(), err)
+	}
+	ti.FreeQuotas()
+	ti.Requeue(task.TaskStatusPreempted, fmt.Sprintf("preempted by task [%s]", job.Instance().Title()), task.SourceScheduler)
+	tp.PushWaitingJob(victim)
+	return true
+}
+
+/**
+ * Check if the job's resource quotas fit the pool and reserve them
+ * Jobs that don't fit stay in the queue with a warning explaining what they wait for
+ */
+func admitJob(job task.TaskJob) bool {
+	ti := job.Instance()
+	if err := ti.AllocQuotas(); err != nil {
+		if gang := ti.GetGang(); gang > 1 {
+			ti.SetWarning(fmt.Sprintf("waiting for resource of all %d replicas: %v", gang, err))
+		} else {
+			ti.SetWarning(fmt.Sprintf("waiting for resource: %v", err))
+		}
+		return false
+	}
+	ti.SetWarning("")
+	return true
+}
+
 /**
  * Process jobs in the waiting queue
+ * Start the highest priority job whose quotas fit the pool's remaining resources,
+ * return false if no job could be dequeued or it failed to start; the pool is notified again
+ * once the failed job is finished, so the queue is not drained while failures pile up in FinishedChan
  */
-func resumeWaitingJob(tp *task.TaskPool) {
-	job, _ := tp.PopWaitingJob()
+func resumeWaitingJob(tp *task.TaskPool) bool {
+	job, _ := tp.PopWaitingJob(admitJob)
 	if job == nil {
-		return
+		return false
 	}
-	err := startJob(job)
-	if err != nil {
+	if err := startJob(job); err != nil {
 		utils.Errorf("Task [%s] start failed: %v", job.Instance().Title(), err)
-	} else {
-		utils.Infof("Task [%s] start succeeded", job.Instance().Title())
+		return false
 	}
+	utils.Infof("Task [%s] start succeeded", job.Instance().Title())
+	return true
 }
 
 /**
@@ -306,39 +561,27 @@ func dealRunningJob(job task.TaskJob) {
 	status := job.FetchStatus()
 	phase := status.Phase()
 	if ti == nil {
-		stopJob(job, task.TaskStatusFailed, fmt.Errorf("failed to get task instance:%v", timeout.Whole))
+		stopJob(job, task.TaskStatusFailed, fmt.Errorf("failed to get task instance:%v", timeout.Whole), task.SourcePoller)
+		return
+	}
+	if phase >= task.PhaseFinished && status != task.TaskStatusSucceeded && ti.Phase() == task.PhaseInit {
+		// Keep the Init phase, so the job can be put back to the queue
+		stopJob(job, status, fmt.Errorf("%s phase ended with %s", ti.Phase().String(), status), task.SourcePoller)
 		return
 	}
 	if phase <= ti.Phase() { // Phase unchanged or state fetch incomplete causing phase miscalculation
-		begTime, maxDuration := ti.GetPhaseTime()
-		if time.Since(begTime) >= maxDuration { // Phase timeout
-			stopJob(job, task.TaskStatusFailed,
-				fmt.Errorf("%s phase execution exceeded limit:%v", ti.Phase().String(), maxDuration))
-			return
-		}
-		if time.Since(*ti.StartTime) >= timeout.Whole { // Task overall timeout
-			stopJob(job, task.TaskStatusFailed,
-				fmt.Errorf("task execution exceeded total time limit:%v", timeout.Whole))
-		}
+		checkTimeout(job)
 		return
 	}
-	now := time.Now().Local()
-	// Task execution phase changed - need to update status, phase, time etc.
-	ti.UpdateStatus(status)
+	// Task execution phase changed - status, phase and times are updated by the state machine
+	ti.UpdateStatus(status, task.SourcePoller)
 	if phase >= task.PhaseFinished { // Task completed
 		utils.Infof("Task [%s] is finished, status: %v", ti.Title(), status)
-		stopJob(job, status, nil)
+		stopJob(job, status, nil, task.SourcePoller)
 		return
-	} else if phase == task.PhaseRunning {
-		ti.RunningTime = &now
-	} else if phase == task.PhaseInit {
-		ti.StartTime = &now
-	}
-	// Task overall timeout
-	if time.Since(*ti.StartTime) >= timeout.Whole {
-		stopJob(job, task.TaskStatusFailed,
-			fmt.Errorf("task execution exceeds total time limit: %v", timeout.Whole))
 	}
+	// Limits of the new phase and the whole limit
+	checkTimeout(job)
 }
 
 /**
@@ -362,32 +605,103 @@ func updateEndlog(job task.TaskJob) {
  */
 func dealFinishedJob(job task.TaskJob) {
 	ti := job.Instance()
-	if !ti.GetStatus().IsFinished() {
-		panic(fmt.Sprintf("Task [%s] is not completed", ti.Title()))
-	}
-	// 1. Stop the task
-	if err := job.Stop(); err != nil {
-		utils.Errorf("Task [%s] stop failed: %s", ti.Title(), err)
+	// The runner may deliver a job again before it is handled, it is queued again or buried by then
+	allJobsMutex.RLock()
+	_, unfinished := allJobs[ti.UUID]
+	allJobsMutex.RUnlock()
+	if !unfinished || !ti.GetStatus().IsFinished() {
+		utils.Debugf("Task [%s] is handled already, status %s", ti.Title(), ti.GetStatus())
+		return
 	}
-	// 2. Release quotas
-	ti.FreeQuotas()
-	// 3. Remove job from task pool
+	// 1. Remove job from task pool first, so the runner stops delivering it
 	tp := ti.GetPool()
 	if tp != nil {
 		tp.RemoveJob(job)
 	}
-	// 4. Notify pool to start a new job
+	// 2. Stop the task
+	if err := job.Stop(); err != nil {
+		utils.Errorf("Task [%s] stop failed: %s", ti.Title(), err)
+	}
+	// 3. Release quotas
+	ti.FreeQuotas()
+	// 4. Put the job back to the head of the queue if it failed to initialize
+	requeued := requeueJob(job)
+	// 5. Notify pool to start a new job
 	tp.SendRunningChan(1)
-	// 5. Record final logs and clean up
+	if requeued {
+		return
+	}
+	// 6. Run the job again if its retry policy allows
+	if retryJob(job) {
+		return
+	}
+	// 7. Record final logs and clean up
 	updateEndlog(job)
-	// 6. Bury the remains
+	// 8. Bury the remains
 	ti.Bury()
-	// 7. Notify next of kin
+	// 9. Notify next of kin
 	ti.SendCallback(ti.GetError())
 
 	allJobsMutex.Lock()
 	delete(allJobs, ti.UUID)
 	allJobsMutex.Unlock()
+	// 10. Release or end dependent tasks, out of this goroutine as they may belong to this pool
+	go resolveDependents(ti)
+}
+
+/**
+ * Put a job failed in Init phase back to the head of its pool's waiting queue
+ * Quotas are already released and partial objects torn down by Stop()
+ */
+func requeueJob(job task.TaskJob) bool {
+	ti := job.Instance()
+	tp := ti.GetPool()
+	if tp == nil || !ti.Requeueable() {
+		return false
+	}
+	ti.Requeues++
+	reason := fmt.Sprintf("re-queued %d/%d after %s phase ended with %s: %s",
+		ti.Requeues, ti.GetRequeueLimit(), ti.Phase().String(), ti.GetStatus(), ti.GetError())
+	if !ti.Requeue(task.TaskStatusQueue, reason, task.SourceScheduler) {
+		ti.Requeues--
+		return false
+	}
+	utils.Infof("Task [%s] %s", ti.Title(), reason)
+	tp.PushFrontWaitingJob(job)
+	return true
+}
+
+/**
+ * Put a finished job back to its pool if the retry policy allows
+ * The job waits for the backoff delay before entering the waiting queue again
+ */
+func retryJob(job task.TaskJob) bool {
+	ti := job.Instance()
+	tp := ti.GetPool()
+	if tp == nil {
+		return false
+	}
+	policy := ti.GetRetryPolicy()
+	attempt := len(ti.Attempts) + 1
+	if !policy.Retryable(attempt, ti.GetStatus(), ti.GetError()) {
+		return false
+	}
+	delay := policy.Delay(attempt)
+	reason := fmt.Sprintf("attempt %d/%d ended with %s, retry after %v: %s",
+		attempt, policy.MaxAttempts, ti.GetStatus(), delay, ti.GetError())
+	ti.AddAttempt()
+	if !ti.Requeue(task.TaskStatusQueue, reason, task.SourceRetry) {
+		return false
+	}
+	utils.Infof("Task [%s] %s", ti.Title(), reason)
+	time.AfterFunc(delay, func() {
+		// The job may have been cancelled or suspended during backoff
+		if ti.GetStatus() != task.TaskStatusQueue {
+			return
+		}
+		tp.SendWaitingChan(job)
+	})
+	return true
 }
 
 /**
@@ -47,19 +47,31 @@ func (mtj *mockTaskJob) Engine() task.TaskEngineKind {
 	return "mock"
 }
 
+// Initialize a task pool, engine of mock tasks is used if the pool sets none
+func newMockPool(pool dao.Pool) *task.TaskPool {
+	if pool.Engine == "" {
+		pool.Engine = "mock"
+	}
+	tp := &task.TaskPool{}
+	tp.Init(&pool)
+	return tp
+}
+
 // Test case 1: Verify behavior when job status is completed, expect sendFinishedChan to be called
 func TestHandleRunningJob_CompletedStatus(t *testing.T) {
 	Convey("当作业状态已完成时，应该调用 sendFinishedChan", t, func() {
 		job := &mockTaskJob{status: task.TaskStatusSucceeded}
+		job.Status = string(task.TaskStatusRunning)
 		tp := &task.TaskPool{}
 		job.AttachPool(tp)
 		sendFinishedChanCalled := false
-		gomonkey.ApplyMethod(reflect.TypeOf(tp), "SendFinishedChan", func(_ *task.TaskPool, job task.TaskJob) {
+		patches := gomonkey.ApplyMethod(reflect.TypeOf(tp), "SendFinishedChan", func(_ *task.TaskPool, job task.TaskJob) {
 			sendFinishedChanCalled = true
 		})
-		gomonkey.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
+		patches.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
 			return nil
 		})
+		defer patches.Reset()
 		dealRunningJob(job)
 
 		So(sendFinishedChanCalled, ShouldBeTrue)
@@ -128,6 +140,58 @@ func TestReloadPoolConfigs(t *testing.T) {
 	})
 }
 
+func TestReconfigurePool(t *testing.T) {
+	Convey("测试任务池在线修改配置", t, func() {
+		tp := &task.TaskPool{}
+		tp.Init(&dao.Pool{PoolId: "test-pool", Engine: "mock", Running: 1, Waiting: 10})
+		allPools = map[string]*task.TaskPool{"test-pool": tp}
+		allJobs = map[string]task.TaskJob{}
+
+		notified := 0
+		patches := gomonkey.ApplyMethod(reflect.TypeOf(tp), "SendRunningChan", func(_ *task.TaskPool, count int) {
+			notified++
+		})
+		patches.ApplyMethod(reflect.TypeOf(tp), "ReloadResources", func(*task.TaskPool) error {
+			return nil
+		})
+		var rebuilt *dao.Pool
+		patches.ApplyFunc(initPool, func(pool dao.Pool) error {
+			rebuilt = &pool
+			return nil
+		})
+		defer patches.Reset()
+
+		Convey("增加并发数后立即调度等待任务", func() {
+			pool := tp.Pool
+			pool.Running = 5
+			So(ReconfigurePool(pool), ShouldBeNil)
+			_, running := tp.GetCapacity()
+			So(running, ShouldEqual, 5)
+			So(notified, ShouldEqual, 1)
+		})
+
+		Convey("有未结束任务时不能修改引擎", func() {
+			job := &mockTaskJob{}
+			job.AttachPool(tp)
+			allJobs["job"] = job
+			pool := tp.Pool
+			pool.Engine = "other"
+			So(ReconfigurePool(pool), ShouldNotBeNil)
+			So(rebuilt, ShouldBeNil)
+		})
+
+		Convey("空任务池修改引擎后重建", func() {
+			pool := tp.Pool
+			pool.Engine = "other"
+			So(ReconfigurePool(pool), ShouldBeNil)
+			So(rebuilt, ShouldNotBeNil)
+			So(rebuilt.Engine, ShouldEqual, "other")
+			_, open := <-tp.Done()
+			So(open, ShouldBeFalse)
+		})
+	})
+}
+
 func TestReloadHistoryTasks(t *testing.T) {
 	Convey("测试重载历史任务", t, func() {
 		Convey("没有未完成任务", func() {
@@ -186,6 +250,24 @@ func TestListPools(t *testing.T) {
 		So(len(pools), ShouldEqual, 2)
 		So(pools[0].PoolId, ShouldEqual, "pool1")
 		So(pools[1].PoolId, ShouldEqual, "pool2")
+
+		Convey("任务池在线变更时列出任务池", func() {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 1000; i++ {
+					allPoolsMutex.Lock()
+					allPools[fmt.Sprintf("pool%d", i%10+3)] = pool1
+					allPoolsMutex.Unlock()
+				}
+			}()
+			for i := 0; i < 100; i++ {
+				ListPools()
+				ReloadPoolConfigs("none")
+			}
+			<-done
+			So(len(ListPools()), ShouldEqual, 12)
+		})
 	})
 }
 
@@ -212,36 +294,44 @@ func TestGetPool(t *testing.T) {
 func TestRemovePool(t *testing.T) {
 	Convey("测试RemovePool函数", t, func() {
 		testPool := &task.TaskPool{}
-		testPool.Pool.PoolId = "test-pool"
+		testPool.Init(&dao.Pool{PoolId: "test-pool", Engine: "mock", Running: 1, Waiting: 1})
 		allPools = map[string]*task.TaskPool{
 			"test-pool": testPool,
 		}
+		allJobs = map[string]task.TaskJob{}
 
 		Convey("移除空的池", func() {
-			patches := gomonkey.ApplyMethod(reflect.TypeOf(testPool), "GetRunningCount", func(*task.TaskPool) int {
-				return 0
-			})
-			patches.ApplyMethod(reflect.TypeOf(testPool), "GetWaitingCount", func(*task.TaskPool) int {
-				return 0
-			})
-			defer patches.Reset()
-
 			err := RemovePool("test-pool")
 			So(err, ShouldBeNil)
 			So(allPools, ShouldNotContainKey, "test-pool")
+
+			closed := false
+			select {
+			case <-testPool.Done():
+				closed = true
+			default:
+			}
+			So(closed, ShouldBeTrue)
 		})
 
 		Convey("移除有任务的池", func() {
-			patches := gomonkey.ApplyMethod(reflect.TypeOf(testPool), "GetRunningCount", func(*task.TaskPool) int {
-				return 1
-			})
-			patches.ApplyMethod(reflect.TypeOf(testPool), "GetWaitingCount", func(*task.TaskPool) int {
-				return 0
-			})
-			defer patches.Reset()
+			job := &mockTaskJob{status: task.TaskStatusRunning}
+			job.AttachPool(testPool)
+			allJobs["running"] = job
+
+			err := RemovePool("test-pool")
+			So(err, ShouldNotBeNil)
+			So(allPools, ShouldContainKey, "test-pool")
+		})
+
+		Convey("移除有队列外任务的池", func() {
+			job := &mockTaskJob{status: task.TaskStatusSuspended}
+			job.AttachPool(testPool)
+			allJobs["suspended"] = job
 
 			err := RemovePool("test-pool")
 			So(err, ShouldNotBeNil)
+			So(allPools, ShouldContainKey, "test-pool")
 		})
 
 		Convey("移除不存在的池", func() {
@@ -288,6 +378,85 @@ func TestGetJob(t *testing.T) {
 	})
 }
 
+func TestSelectPool_Draining(t *testing.T) {
+	Convey("测试selectPool跳过排空中的任务池", t, func() {
+		big := newMockPool(dao.Pool{PoolId: "big", Running: 1, Waiting: 10})
+		small := newMockPool(dao.Pool{PoolId: "small", Running: 1, Waiting: 5})
+		allPools = map[string]*task.TaskPool{"big": big, "small": small}
+		job := &mockTaskJob{}
+
+		Convey("选择等待容量最大的任务池", func() {
+			tp, err := selectPool(job)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, big)
+		})
+
+		Convey("排空中的任务池不接收新任务", func() {
+			So(big.SetState(task.PoolStateDraining), ShouldBeNil)
+			tp, err := selectPool(job)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, small)
+
+			So(small.SetState(task.PoolStateDraining), ShouldBeNil)
+			_, err = selectPool(job)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("暂停的任务池仍接收新任务", func() {
+			So(big.SetState(task.PoolStatePaused), ShouldBeNil)
+			tp, err := selectPool(job)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, big)
+		})
+	})
+}
+
+func TestSelectPool_Labels(t *testing.T) {
+	Convey("测试selectPool按标签和容量打分选择任务池", t, func() {
+		sh := newMockPool(dao.Pool{PoolId: "sh", Running: 1, Waiting: 5, Labels: "gpu=a800,region=sh"})
+		bj := newMockPool(dao.Pool{PoolId: "bj", Running: 4, Waiting: 5, Labels: "gpu=a800,region=bj"})
+		cpu := newMockPool(dao.Pool{PoolId: "cpu", Running: 8, Waiting: 5, Labels: "region=sh"})
+		allPools = map[string]*task.TaskPool{"sh": sh, "bj": bj, "cpu": cpu}
+		job := &mockTaskJob{}
+
+		Convey("无选择器时选择空闲运行槽位最多的任务池", func() {
+			tp, err := selectPool(job)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, cpu)
+		})
+
+		Convey("只在匹配选择器的任务池中选择", func() {
+			job.PoolSelector = "gpu=a800"
+			tp, err := selectPool(job)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, bj)
+		})
+
+		Convey("偏好优先于容量", func() {
+			job.PoolSelector = "gpu"
+			job.PoolPreferences = []dao.PoolPreference{{Selector: "region=sh", Weight: 1}}
+			tp, err := selectPool(job)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, sh)
+		})
+
+		Convey("没有匹配的任务池时报错", func() {
+			job.PoolSelector = "gpu=h100"
+			_, err := selectPool(job)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "gpu=h100")
+		})
+
+		Convey("指定任务池时忽略选择器", func() {
+			job.Pool = "cpu"
+			job.PoolSelector = "gpu=a800"
+			tp, err := selectPool(job)
+			So(err, ShouldBeNil)
+			So(tp, ShouldEqual, cpu)
+		})
+	})
+}
+
 func TestPoolNewJob(t *testing.T) {
 	Convey("测试PoolNewJob函数", t, func() {
 		testTaskRec := &dao.TaskRec{
@@ -351,7 +520,7 @@ func TestCancelJob(t *testing.T) {
 		}
 
 		Convey("取消存在的任务", func() {
-			patches := gomonkey.ApplyFunc(stopJob, func(job task.TaskJob, status task.TaskStatus, err error) {
+			patches := gomonkey.ApplyFunc(stopJob, func(job task.TaskJob, status task.TaskStatus, err error, source string) {
 				// mock stopJob
 			})
 			defer patches.Reset()
@@ -381,3 +550,106 @@ func TestCancelJob(t *testing.T) {
 		})
 	})
 }
+
+// Job whose start always fails, e.g. kubectl apply rejected
+type failingTaskJob struct {
+	mockTaskJob
+}
+
+func (ftj *failingTaskJob) Start() error {
+	return fmt.Errorf("kubectl apply failed")
+}
+
+func TestHandleRunningChan_FailedStarts(t *testing.T) {
+	Convey("启动失败的任务不会阻塞任务池", t, func() {
+		tp := &task.TaskPool{}
+		tp.Init(&dao.Pool{PoolId: "failing", Engine: "mock", Running: 1, Waiting: 10})
+		defer tp.Close()
+		allPools = map[string]*task.TaskPool{"failing": tp}
+		allJobs = map[string]task.TaskJob{}
+		pendingJobs = make(map[string]task.TaskJob)
+
+		patches := gomonkey.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
+			return nil
+		})
+		patches.ApplyMethod(reflect.TypeOf(&dao.TaskRec{}), "Bury", func(*dao.TaskRec) error {
+			return nil
+		})
+		patches.ApplyFunc(dao.ListDependents, func(uuid string) ([]string, error) {
+			return nil, nil
+		})
+		defer patches.Reset()
+
+		for i := 0; i < 3; i++ {
+			job := &failingTaskJob{}
+			job.UUID = fmt.Sprintf("failing-%d", i)
+			job.Status = string(task.TaskStatusQueue)
+			job.AttachPool(tp)
+			allJobs[job.UUID] = job
+			tp.PushWaitingJob(job)
+		}
+		go handleRunningChan(tp)
+		go handleFinishedChan(tp)
+		tp.SendRunningChan(1)
+
+		// Every job is re-queued up to the limit and then ends as Failed
+		finished := func() bool {
+			allJobsMutex.RLock()
+			defer allJobsMutex.RUnlock()
+			return len(allJobs) == 0
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for !finished() && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		So(finished(), ShouldBeTrue)
+		So(tp.GetWaitingCount(), ShouldEqual, 0)
+	})
+}
+
+func TestDealFinishedJob_Duplicate(t *testing.T) {
+	Convey("同一个结束的任务被重复投递", t, func() {
+		tp := &task.TaskPool{}
+		tp.Init(&dao.Pool{PoolId: "finished", Engine: "mock", Running: 1, Waiting: 10})
+		allJobs = map[string]task.TaskJob{}
+		patches := gomonkey.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
+			return nil
+		})
+		patches.ApplyMethod(reflect.TypeOf(tp), "SendRunningChan", func(*task.TaskPool, int) {})
+		var timers []func()
+		patches.ApplyFunc(time.AfterFunc, func(d time.Duration, f func()) *time.Timer {
+			timers = append(timers, f)
+			return nil
+		})
+		defer patches.Reset()
+
+		job := &mockTaskJob{}
+		job.UUID = "finished"
+		job.Status = string(task.TaskStatusQueue)
+		job.AttachPool(tp)
+		allJobs[job.UUID] = job
+		So(job.Prerun(), ShouldBeTrue)
+		tp.AddRunningJob(job)
+
+		Convey("Init阶段失败后只重新排队一次", func() {
+			job.Finish(task.TaskStatusFailed, fmt.Errorf("image pull failed"), task.SourcePoller)
+			So(func() { dealFinishedJob(job) }, ShouldNotPanic)
+			So(func() { dealFinishedJob(job) }, ShouldNotPanic)
+			So(job.GetStatus(), ShouldEqual, task.TaskStatusQueue)
+			So(job.Requeues, ShouldEqual, 1)
+			So(tp.GetWaitingCount(), ShouldEqual, 1)
+			So(tp.GetRunningCount(), ShouldEqual, 0)
+		})
+
+		Convey("运行失败后只重试一次", func() {
+			job.Retry = `{"max_attempts": 3}`
+			job.UpdateStatus(task.TaskStatusRunning, task.SourcePoller)
+			job.Finish(task.TaskStatusFailed, fmt.Errorf("exit code 1"), task.SourcePoller)
+			So(func() { dealFinishedJob(job) }, ShouldNotPanic)
+			So(func() { dealFinishedJob(job) }, ShouldNotPanic)
+			So(job.GetStatus(), ShouldEqual, task.TaskStatusQueue)
+			So(job.Attempts, ShouldHaveLength, 1)
+			So(timers, ShouldHaveLength, 1)
+		})
+	})
+}
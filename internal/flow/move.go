@@ -0,0 +1,79 @@
+/**
+ * Move: transfer a task that has not started to another pool
+ */
+package flow
+
+import (
+	"fmt"
+	"net/http"
+	"taskd/internal/task"
+	"taskd/internal/utils"
+)
+
+/**
+ * Move a task that has not started to another pool with the same engine
+ * A queued job is taken out of the waiting queue (or backlog) of its pool and queued in the target,
+ * it keeps its queue time and creation order, so the time already spent waiting still counts
+ * for queue timeout and it is placed among jobs of the same priority by when it was created
+ * Pending, scheduled and suspended jobs are only attached to the target, they are queued there later
+ */
+func MoveJob(uuid string, poolId string) error {
+	job, err := lookupJob(uuid)
+	if err != nil {
+		return err
+	}
+	ti := job.Instance()
+	from := ti.GetPool()
+	target := GetPool(poolId)
+	if target == nil {
+		return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("pool [%s] is not exist", poolId))
+	}
+	if target == from {
+		return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("task [%s] is already in pool [%s]", uuid, poolId))
+	}
+	if task.TaskEngineKind(target.Engine) != job.Engine() {
+		return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("pool [%s] runs the [%s] engine, but task [%s] requires [%s]",
+			poolId, target.Engine, uuid, job.Engine()))
+	}
+	if !target.IsAccepting() {
+		return utils.NewHttpError(http.StatusServiceUnavailable, fmt.Sprintf("pool [%s] is draining, no task can be moved in", poolId))
+	}
+
+	backlog := false
+	switch status := ti.GetStatus(); status {
+	case task.TaskStatusQueue, task.TaskStatusPreempted:
+		if target.IsFull() {
+			bp := target.GetBackpressure()
+			if bp.Mode != task.BackpressureBacklog {
+				return utils.NewHttpError(http.StatusTooManyRequests,
+					fmt.Sprintf("waiting queue of pool [%s] is full", poolId)).WithRetryAfter(bp.RetryAfter)
+			}
+			backlog = true
+		}
+		// The job may be in retry backoff, still in WaitingChan or dequeued to start, it cannot be taken safely then
+		if err := from.TakeWaitingJob(job); err != nil {
+			return utils.NewHttpError(http.StatusConflict, fmt.Sprintf("task [%s] is not in the waiting queue, please try again", uuid))
+		}
+	case task.TaskStatusPending, task.TaskStatusScheduled, task.TaskStatusSuspended:
+	default:
+		return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("task [%s] is %s, cannot be moved", uuid, status))
+	}
+
+	utils.Infof("Task [%s] is moved from pool [%s] to [%s]", ti.Title(), from.PoolId, poolId)
+	ti.Pool = poolId
+	ti.AttachPool(target)
+	ti.SetWarning(fmt.Sprintf("moved from pool [%s]", from.PoolId))
+	ti.Update()
+
+	switch ti.GetStatus() {
+	case task.TaskStatusQueue, task.TaskStatusPreempted:
+		if backlog {
+			target.PushBacklogJob(job)
+		} else {
+			target.SendWaitingChan(job)
+		}
+		// The slot left behind may be taken by a job waiting in the backlog of the source pool
+		from.SendRunningChan(1)
+	}
+	return nil
+}
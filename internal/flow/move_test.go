@@ -0,0 +1,88 @@
+package flow
+
+import (
+	"reflect"
+	"taskd/dao"
+	"taskd/internal/task"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMoveJob(t *testing.T) {
+	Convey("测试在任务池之间移动任务", t, func() {
+		from := newMockPool(dao.Pool{PoolId: "from", Running: 1, Waiting: 10})
+		to := newMockPool(dao.Pool{PoolId: "to", Running: 1, Waiting: 10})
+		other := newMockPool(dao.Pool{PoolId: "other", Engine: "pod", Running: 1, Waiting: 10})
+		allPools = map[string]*task.TaskPool{"from": from, "to": to, "other": other}
+		queueTime := time.Now().Add(-time.Hour)
+		job := &mockTaskJob{}
+		job.UUID = "move"
+		job.Pool = "from"
+		job.QueueTime = &queueTime
+		job.AttachPool(from)
+		allJobs = map[string]task.TaskJob{"move": job}
+
+		var queued []task.TaskJob
+		patches := gomonkey.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
+			return nil
+		})
+		patches.ApplyMethod(reflect.TypeOf(to), "SendWaitingChan", func(_ *task.TaskPool, job task.TaskJob) {
+			queued = append(queued, job)
+		})
+		patches.ApplyMethod(reflect.TypeOf(to), "SendRunningChan", func(_ *task.TaskPool, count int) {})
+		defer patches.Reset()
+
+		Convey("等待中的任务移到目标任务池并保留排队时间", func() {
+			job.Status = string(task.TaskStatusQueue)
+			from.PushWaitingJob(job)
+			So(MoveJob("move", "to"), ShouldBeNil)
+			So(from.GetWaitingCount(), ShouldEqual, 0)
+			So(queued, ShouldHaveLength, 1)
+			So(job.GetPool(), ShouldEqual, to)
+			So(job.Pool, ShouldEqual, "to")
+			So(*job.QueueTime, ShouldEqual, queueTime)
+		})
+
+		Convey("挂起的任务只更换任务池", func() {
+			job.Status = string(task.TaskStatusSuspended)
+			So(MoveJob("move", "to"), ShouldBeNil)
+			So(job.GetPool(), ShouldEqual, to)
+			So(queued, ShouldBeEmpty)
+		})
+
+		Convey("不在等待队列中的任务不能移动", func() {
+			job.Status = string(task.TaskStatusQueue)
+			So(MoveJob("move", "to"), ShouldNotBeNil)
+			So(job.GetPool(), ShouldEqual, from)
+		})
+
+		Convey("已出队待启动的任务不能移动", func() {
+			job.Status = string(task.TaskStatusQueue)
+			from.AddRunningJob(job)
+			So(MoveJob("move", "to"), ShouldNotBeNil)
+			So(from.GetRunningCount(), ShouldEqual, 1)
+			So(job.GetPool(), ShouldEqual, from)
+		})
+
+		Convey("运行中的任务不能移动", func() {
+			job.Status = string(task.TaskStatusRunning)
+			So(MoveJob("move", "to"), ShouldNotBeNil)
+		})
+
+		Convey("引擎不同的任务池不能移入", func() {
+			job.Status = string(task.TaskStatusQueue)
+			from.PushWaitingJob(job)
+			So(MoveJob("move", "other"), ShouldNotBeNil)
+			So(from.GetWaitingCount(), ShouldEqual, 1)
+		})
+
+		Convey("排空中的任务池不能移入", func() {
+			job.Status = string(task.TaskStatusQueue)
+			So(to.SetState(task.PoolStateDraining), ShouldBeNil)
+			So(MoveJob("move", "to"), ShouldNotBeNil)
+		})
+	})
+}
@@ -1,6 +1,9 @@
 package flow
 
-import "taskd/internal/task"
+import (
+	"taskd/internal/task"
+	"time"
+)
 
 type JobEventKind string
 
@@ -21,12 +24,14 @@ type JobEvent struct {
 type Reactor struct {
 	taskPool *task.TaskPool
 	events   chan JobEvent
+	jobs     map[string]task.TaskJob // Started jobs not ended yet, watched for timeout
 }
 
 func NewReactor(taskPool *task.TaskPool) task.Runner {
 	return &Reactor{
 		taskPool: taskPool,
 		events:   make(chan JobEvent, taskPool.Running*3),
+		jobs:     make(map[string]task.TaskJob),
 	}
 }
 
@@ -55,17 +60,53 @@ func (r *Reactor) Pool() *task.TaskPool {
 	return r.taskPool
 }
 
+/**
+ * Apply job events in order, and check time limits of started jobs every second
+ * Events are only reported by jobs, so a job that never ends is stopped by its deadline
+ */
 func (r *Reactor) Run() {
-	// Missing timeout logic
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
 	for {
-		event := <-r.events
+		var event JobEvent
+		select {
+		case event = <-r.events:
+		case <-ticker.C:
+			r.checkTimeouts()
+			continue
+		case <-r.taskPool.Done():
+			return
+		}
+		uuid := event.Job.Instance().UUID
 		switch event.Kind {
 		case JobEventStart:
-			event.Job.Instance().UpdateStatus(task.TaskStatusInit)
+			r.jobs[uuid] = event.Job
+			// The job reports Init before it runs on its own, it may be running already when its start is handled
+			if ti := event.Job.Instance(); ti.GetStatus() == task.TaskStatusInit {
+				ti.UpdateStatus(task.TaskStatusInit, task.SourceReactor)
+			}
 		case JobEventRunning:
-			event.Job.Instance().UpdateStatus(task.TaskStatusRunning)
+			if _, ok := r.jobs[uuid]; ok {
+				event.Job.Instance().UpdateStatus(task.TaskStatusRunning, task.SourceReactor)
+			}
 		case JobEventEnd:
+			if _, ok := r.jobs[uuid]; !ok { // Already stopped by timeout
+				continue
+			}
+			delete(r.jobs, uuid)
 			r.taskPool.SendFinishedChan(event.Job)
 		}
 	}
 }
+
+/**
+ * Stop started jobs exceeding their time limits, their end events are ignored afterwards
+ * Jobs sent back to the queue by suspension or preemption have left the running table, they are not watched any more
+ */
+func (r *Reactor) checkTimeouts() {
+	for uuid, job := range r.jobs {
+		if job.Instance().GetStatus().Phase() == task.PhaseQueue || checkTimeout(job) {
+			delete(r.jobs, uuid)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package flow
+
+import (
+	"fmt"
+	"reflect"
+	"taskd/dao"
+	"taskd/internal/task"
+	"taskd/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestReactor_StartEvent(t *testing.T) {
+	Convey("Reactor处理启动事件时任务已经在运行", t, func() {
+		tp := &task.TaskPool{}
+		tp.Init(&dao.Pool{PoolId: "reactor", Engine: "mock", Running: 1, Waiting: 10})
+		defer tp.Close()
+		ended := make(chan task.TaskJob, 1)
+		patches := gomonkey.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
+			return nil
+		})
+		patches.ApplyMethod(reflect.TypeOf(tp), "SendFinishedChan", func(_ *task.TaskPool, job task.TaskJob) {
+			ended <- job
+		})
+		var errs []string
+		patches.ApplyFunc(utils.Errorf, func(format string, args ...any) {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		})
+		defer patches.Reset()
+
+		job := &mockTaskJob{}
+		job.UUID = "reactor"
+		job.Status = string(task.TaskStatusInit)
+		job.AttachPool(tp)
+		// The request goroutine reports Running before the reactor handles the start event
+		job.SetStatus(task.TaskStatusRunning)
+
+		r := NewReactor(tp).(*Reactor)
+		go r.Run()
+		r.OnJobStart(job)
+		r.OnJobEnd(job)
+		So(<-ended, ShouldEqual, job)
+		So(job.GetStatus(), ShouldEqual, task.TaskStatusRunning)
+		So(job.Events, ShouldHaveLength, 1)
+		So(errs, ShouldBeEmpty)
+	})
+}
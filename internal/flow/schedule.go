@@ -4,32 +4,82 @@
 package flow
 
 import (
-	"fmt"
 	"taskd/internal/task"
+	"taskd/internal/utils"
 	"time"
 )
 
+/**
+ * Put a job into its pool's waiting queue, or hold it in Scheduled state until start_after
+ * The queue phase timeout counts from the time the job enters the queue
+ */
+func enqueueJob(job task.TaskJob) {
+	ti := job.Instance()
+	if ti.HoldUntilStart() {
+		utils.Infof("Task [%s] is scheduled to start after %v", ti.Title(), ti.StartAfter)
+		time.AfterFunc(time.Until(*ti.StartAfter), func() {
+			// The job may have been cancelled while scheduled
+			if ti.GetStatus() != task.TaskStatusScheduled {
+				return
+			}
+			ti.Requeue(task.TaskStatusQueue, "", task.SourceScheduler)
+			ti.GetPool().SendWaitingChan(job)
+		})
+		return
+	}
+	if ti.GetStatus() == task.TaskStatusScheduled { // Reloaded after start_after
+		ti.Requeue(task.TaskStatusQueue, "", task.SourceScheduler)
+	}
+	ti.GetPool().SendWaitingChan(job)
+}
+
 /**
  * Notify when tasks are waiting to run
  */
 func handleWaitingChan(tp *task.TaskPool) {
 	for {
-		job := <-tp.WaitingChan
+		var job task.TaskJob
+		select {
+		case job = <-tp.WaitingChan:
+		case <-tp.Done():
+			return
+		}
+		if job.Instance().GetStatus() == task.TaskStatusSuspended { // Suspended before entering the queue
+			continue
+		}
 		tp.PushWaitingJob(job)
+		if !tp.IsStartable() { // Paused pool keeps the job waiting
+			continue
+		}
 		if _, running := tp.GetCapacity(); running > 0 {
 			tp.SendRunningChan(1)
+		} else if preemptFor(tp, job) { // Pool is full, make room for higher priority job
+			tp.SendRunningChan(1)
 		}
 	}
 }
 
 /**
  * Notify runner to start waiting tasks
+ * Freed resources may admit several small tasks at once, so keep starting
+ * tasks while there are running slots and admissible tasks
+ * A paused pool starts nothing, waiting tasks are started on resume
  */
 func handleRunningChan(tp *task.TaskPool) {
 	for {
-		count := <-tp.RunningChan // Number of tasks to start
-		for i := 0; i < count; i++ {
-			resumeWaitingJob(tp)
+		select {
+		case <-tp.RunningChan:
+		case <-tp.Done():
+			return
+		}
+		for tp.IsStartable() {
+			tp.FillFromBacklog()
+			if _, running := tp.GetCapacity(); running <= 0 {
+				break
+			}
+			if !resumeWaitingJob(tp) {
+				break
+			}
 		}
 	}
 }
@@ -39,24 +89,31 @@ func handleRunningChan(tp *task.TaskPool) {
  */
 func handleFinishedChan(tp *task.TaskPool) {
 	for {
-		job := <-tp.FinishedChan
-		dealFinishedJob(job)
+		select {
+		case job := <-tp.FinishedChan:
+			dealFinishedJob(job)
+		case <-tp.Done():
+			return
+		}
 	}
 }
 
 /**
  * Handle all queued waiting tasks (mainly timeout processing)
+ * Jobs parked in backlog are moved to the waiting queue once it has room
  */
 func handleWaitingJobs(tp *task.TaskPool) {
 	for {
-		<-time.After(1 * time.Second)
+		select {
+		case <-time.After(1 * time.Second):
+		case <-tp.Done():
+			return
+		}
+		if tp.FillFromBacklog() > 0 && tp.IsStartable() {
+			tp.SendRunningChan(1)
+		}
 		tp.ForeachWaiting(func(job task.TaskJob) error {
-			ti := job.Instance()
-			begTime, maxDuration := ti.GetPhaseTime()
-			if time.Since(begTime) >= maxDuration { // Queue timeout
-				stopJob(job, task.TaskStatusKilled,
-					fmt.Errorf("%s phase timeout: %v", ti.Phase().String(), maxDuration))
-			}
+			checkTimeout(job)
 			return nil
 		})
 	}
@@ -67,7 +124,11 @@ func handleWaitingJobs(tp *task.TaskPool) {
  */
 func handleRunningJobs(tp *task.TaskPool) {
 	for {
-		<-time.After(1 * time.Second)
+		select {
+		case <-time.After(1 * time.Second):
+		case <-tp.Done():
+			return
+		}
 		tp.ForeachRunning(func(job task.TaskJob) error {
 			if !job.Instance().GetStatus().IsFinished() {
 				dealRunningJob(job)
@@ -0,0 +1,82 @@
+/**
+ * Suspend: yield resources of a task and run it again later
+ */
+package flow
+
+import (
+	"fmt"
+	"net/http"
+	"taskd/internal/task"
+	"taskd/internal/utils"
+)
+
+/**
+ * Find an unfinished job by task UUID
+ */
+func lookupJob(uuid string) (task.TaskJob, error) {
+	allJobsMutex.RLock()
+	job, ok := allJobs[uuid]
+	allJobsMutex.RUnlock()
+	if !ok {
+		return nil, utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("task [%s] is not exist or has finished", uuid))
+	}
+	return job, nil
+}
+
+/**
+ * Suspend a queued or running task
+ * The workload is torn down by the engine's Stop() and the pool slot and quotas are released,
+ * the task keeps its record and compiled YamlContent until it is resumed
+ */
+func SuspendJob(uuid string) error {
+	job, err := lookupJob(uuid)
+	if err != nil {
+		return err
+	}
+	ti := job.Instance()
+	tp := ti.GetPool()
+	switch status := ti.GetStatus(); status {
+	case task.TaskStatusQueue, task.TaskStatusPreempted, task.TaskStatusInit, task.TaskStatusRunning:
+	default:
+		return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("task [%s] is %s, cannot be suspended", uuid, status))
+	}
+	// Change the status first, a job the state machine refuses to suspend is left where it is
+	if !ti.Requeue(task.TaskStatusSuspended, "suspended by user", task.SourceUser) {
+		return utils.NewHttpError(http.StatusConflict, fmt.Sprintf("task [%s] is %s now, cannot be suspended", uuid, ti.GetStatus()))
+	}
+	// The job may have started since its status was read, it is torn down by the status it is suspended from
+	if from := task.TaskStatus(ti.LastEvent().From); from.Phase() == task.PhaseQueue {
+		// The job may be in retry backoff or still in WaitingChan, it is held by its status then
+		tp.RemoveJob(job)
+	} else {
+		// Leave the running table first, so the runner stops watching the job being torn down
+		tp.RemoveRunningJob(job)
+		if err := job.Stop(); err != nil {
+			utils.Errorf("Task [%s] stop failed: %s", ti.Title(), err)
+		}
+		ti.FreeQuotas()
+		tp.SendRunningChan(1)
+	}
+	utils.Infof("Task [%s] is suspended", ti.Title())
+	return nil
+}
+
+/**
+ * Resume a suspended task, it is queued again with the same compiled YamlContent
+ */
+func ResumeJob(uuid string) error {
+	job, err := lookupJob(uuid)
+	if err != nil {
+		return err
+	}
+	ti := job.Instance()
+	if status := ti.GetStatus(); status != task.TaskStatusSuspended {
+		return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("task [%s] is %s, not suspended", uuid, status))
+	}
+	if !ti.Requeue(task.TaskStatusQueue, "", task.SourceUser) {
+		return utils.NewHttpError(http.StatusConflict, fmt.Sprintf("task [%s] is %s now, cannot be resumed", uuid, ti.GetStatus()))
+	}
+	utils.Infof("Task [%s] is resumed", ti.Title())
+	enqueueJob(job)
+	return nil
+}
@@ -0,0 +1,97 @@
+package flow
+
+import (
+	"net/http"
+	"reflect"
+	"taskd/dao"
+	"taskd/internal/task"
+	"taskd/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSuspendJob(t *testing.T) {
+	Convey("测试挂起和恢复任务", t, func() {
+		tp := &task.TaskPool{}
+		tp.Init(&dao.Pool{PoolId: "test", Running: 1, Waiting: 10})
+		job := &mockTaskJob{}
+		job.UUID = "suspend"
+		job.YamlContent = "kind: Pod"
+		job.AttachPool(tp)
+		allJobs = map[string]task.TaskJob{"suspend": job}
+
+		var queued []task.TaskJob
+		notified := 0
+		patches := gomonkey.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
+			return nil
+		})
+		patches.ApplyMethod(reflect.TypeOf(tp), "SendWaitingChan", func(_ *task.TaskPool, job task.TaskJob) {
+			queued = append(queued, job)
+		})
+		patches.ApplyMethod(reflect.TypeOf(tp), "SendRunningChan", func(_ *task.TaskPool, count int) {
+			notified++
+		})
+		defer patches.Reset()
+
+		Convey("挂起运行中的任务释放运行槽位", func() {
+			job.Status = string(task.TaskStatusRunning)
+			tp.AddRunningJob(job)
+			So(SuspendJob("suspend"), ShouldBeNil)
+			So(job.GetStatus(), ShouldEqual, task.TaskStatusSuspended)
+			So(tp.GetRunningCount(), ShouldEqual, 0)
+			So(notified, ShouldEqual, 1)
+			So(allJobs, ShouldContainKey, "suspend")
+
+			Convey("恢复后以相同的YamlContent重新排队", func() {
+				So(ResumeJob("suspend"), ShouldBeNil)
+				So(job.GetStatus(), ShouldEqual, task.TaskStatusQueue)
+				So(queued, ShouldHaveLength, 1)
+				So(job.YamlContent, ShouldEqual, "kind: Pod")
+			})
+		})
+
+		Convey("挂起等待中的任务移出等待队列", func() {
+			job.Status = string(task.TaskStatusQueue)
+			tp.PushWaitingJob(job)
+			So(SuspendJob("suspend"), ShouldBeNil)
+			So(job.GetStatus(), ShouldEqual, task.TaskStatusSuspended)
+			So(tp.GetWaitingCount(), ShouldEqual, 0)
+		})
+
+		Convey("状态机拒绝时返回冲突且不改变队列", func() {
+			job.Status = string(task.TaskStatusRunning)
+			tp.AddRunningJob(job)
+			patches.ApplyMethod(reflect.TypeOf(&job.TaskInstance), "Requeue", func(*task.TaskInstance, task.TaskStatus, string, string) bool {
+				return false
+			})
+			err := SuspendJob("suspend")
+			So(err, ShouldNotBeNil)
+			So(err.(*utils.HttpError).Code(), ShouldEqual, http.StatusConflict)
+			So(tp.GetRunningCount(), ShouldEqual, 1)
+			So(notified, ShouldEqual, 0)
+
+			job.Status = string(task.TaskStatusSuspended)
+			err = ResumeJob("suspend")
+			So(err, ShouldNotBeNil)
+			So(err.(*utils.HttpError).Code(), ShouldEqual, http.StatusConflict)
+			So(queued, ShouldBeEmpty)
+		})
+
+		Convey("未挂起的任务不能恢复", func() {
+			job.Status = string(task.TaskStatusRunning)
+			So(ResumeJob("suspend"), ShouldNotBeNil)
+		})
+
+		Convey("依赖等待中的任务不能挂起", func() {
+			job.Status = string(task.TaskStatusPending)
+			So(SuspendJob("suspend"), ShouldNotBeNil)
+		})
+
+		Convey("不存在的任务", func() {
+			So(SuspendJob("none"), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,51 @@
+/**
+ * Timeout: time limits of jobs, shared by waiting jobs and all runners
+ */
+package flow
+
+import (
+	"taskd/internal/task"
+	"taskd/internal/utils"
+	"time"
+)
+
+/**
+ * Enforce the time limits of an unfinished job
+ * The job is stopped as Killed once it exceeds the limit of its current phase or its whole limit,
+ * the engine's Stop() then tears it down (e.g. cancels the request of an RPC job)
+ * Before that it is warned once per limit when the warning percentage of the limit is reached
+ * Returns true if the job is stopped
+ */
+func checkTimeout(job task.TaskJob) bool {
+	ti := job.Instance()
+	now := time.Now()
+	deadlines := ti.GetDeadlines()
+	for _, d := range deadlines {
+		if d.Exceeded(now) {
+			utils.Infof("Task [%s] is killed: %v", ti.Title(), d.Error())
+			stopJob(job, task.TaskStatusKilled, d.Error(), task.SourceTimeout)
+			return true
+		}
+	}
+	for _, d := range deadlines {
+		if ti.MarkTimeoutWarned(d, now) {
+			warnTimeout(ti, d)
+		}
+	}
+	return false
+}
+
+/**
+ * Warn a job approaching its deadline through its Warning field and callback
+ */
+func warnTimeout(ti *task.TaskInstance, d task.Deadline) {
+	message := d.Warning(ti.GetTimeout().Warn)
+	utils.Infof("Task [%s] %s", ti.Title(), message)
+	ti.SetWarning(message)
+	ti.Update()
+	go func() {
+		if err := ti.SendWarningCallback(message); err != nil {
+			utils.Errorf("Task [%s] send warning callback failed: %v", ti.Title(), err)
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+package flow
+
+import (
+	"reflect"
+	"taskd/dao"
+	"taskd/internal/task"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCheckTimeout(t *testing.T) {
+	Convey("测试任务超时检查", t, func() {
+		tp := &task.TaskPool{}
+		tp.Init(&dao.Pool{PoolId: "test", Engine: "mock", Running: 1, Waiting: 10})
+		var finished []task.TaskJob
+		patches := gomonkey.ApplyFunc(dao.SetJSON, func(string, any, time.Duration) error {
+			return nil
+		})
+		patches.ApplyMethod(reflect.TypeOf(tp), "SendFinishedChan", func(_ *task.TaskPool, job task.TaskJob) {
+			finished = append(finished, job)
+		})
+		defer patches.Reset()
+
+		started := time.Now().Add(-40 * time.Minute)
+		job := &mockTaskJob{}
+		job.UUID = "timeout"
+		job.Timeout = `{"running": 60, "warn": 50}`
+		job.StartTime = &started
+		job.Status = string(task.TaskStatusInit)
+		job.AttachPool(tp)
+		job.UpdateStatus(task.TaskStatusRunning, task.SourcePoller)
+		job.RunningTime = &started
+
+		Convey("达到告警比例时只告警一次", func() {
+			So(checkTimeout(job), ShouldBeFalse)
+			So(job.Warning, ShouldContainSubstring, "Running phase execution reached 50%")
+			job.SetWarning("")
+			So(checkTimeout(job), ShouldBeFalse)
+			So(job.Warning, ShouldBeEmpty)
+			So(finished, ShouldBeEmpty)
+		})
+
+		Convey("Running阶段超时后停止任务", func() {
+			job.Timeout = `{"running": 30}`
+			So(checkTimeout(job), ShouldBeTrue)
+			So(job.GetStatus(), ShouldEqual, task.TaskStatusKilled)
+			So(job.Error, ShouldContainSubstring, "Running phase execution exceeded limit")
+			So(finished, ShouldHaveLength, 1)
+		})
+
+		Convey("Reactor停止超时的任务并忽略之后的结束事件", func() {
+			job.Timeout = `{"running": 30}`
+			r := NewReactor(tp).(*Reactor)
+			r.jobs[job.UUID] = job
+			r.checkTimeouts()
+			So(r.jobs, ShouldBeEmpty)
+			So(finished, ShouldHaveLength, 1)
+		})
+
+		Convey("Reactor不再监视被挂起的任务", func() {
+			job.Timeout = `{"queue": 30}`
+			r := NewReactor(tp).(*Reactor)
+			r.jobs[job.UUID] = job
+			tp.AddRunningJob(job)
+			allJobs = map[string]task.TaskJob{job.UUID: job}
+			So(SuspendJob(job.UUID), ShouldBeNil)
+			queued := time.Now().Add(-40 * time.Minute)
+			job.QueueTime = &queued
+			r.checkTimeouts()
+			So(r.jobs, ShouldBeEmpty)
+			So(job.GetStatus(), ShouldEqual, task.TaskStatusSuspended)
+			So(finished, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+package task
+
+import (
+	"fmt"
+)
+
+/**
+ *	Handling of new submissions when the waiting queue of a pool is full
+ */
+const (
+	BackpressureReject  = "reject"  // Reject with 429 and Retry-After (default)
+	BackpressureSpill   = "spill"   // Submit to another pool with the same engine, reject if none has room
+	BackpressureBacklog = "backlog" // Park in an unbounded backlog, moved to the waiting queue as it drains
+)
+
+/**
+ *	Default seconds a rejected client should wait before retrying
+ */
+const defaultRetryAfter = 30
+
+/**
+ *	Backpressure settings of a pool, e.g:
+ *	{"mode": "reject", "retry_after": 60}
+ *	{"mode": "spill", "spill_to": ["gpu-b", "gpu-c"]}
+ */
+type Backpressure struct {
+	Mode       string   `json:"mode"`                  // reject, spill or backlog
+	RetryAfter int      `json:"retry_after,omitempty"` // Seconds in Retry-After header of rejections, default 30
+	SpillTo    []string `json:"spill_to,omitempty"`    // Pools to spill to in order, any pool with the same engine if empty
+}
+
+/**
+ *	Check backpressure settings
+ */
+func (bp *Backpressure) check() error {
+	switch bp.Mode {
+	case BackpressureReject, BackpressureSpill, BackpressureBacklog:
+	default:
+		return fmt.Errorf("backpressure mode must be '%s', '%s' or '%s'", BackpressureReject, BackpressureSpill, BackpressureBacklog)
+	}
+	if bp.RetryAfter < 0 {
+		return fmt.Errorf("backpressure retry_after cannot be negative")
+	}
+	return nil
+}
+
+/**
+ *	Backpressure settings of the pool, with defaults filled in
+ */
+func (tp *TaskPool) GetBackpressure() Backpressure {
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	return tp.getBackpressure()
+}
+
+/**
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) getBackpressure() Backpressure {
+	result := Backpressure{Mode: BackpressureReject}
+	if bp := tp.policy.Backpressure; bp != nil {
+		result = *bp
+	}
+	if result.RetryAfter == 0 {
+		result.RetryAfter = defaultRetryAfter
+	}
+	return result
+}
+
+/**
+ *	Check if the waiting queue is full, including jobs on their way through WaitingChan
+ */
+func (tp *TaskPool) IsFull() bool {
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	return tp.waitings.Len()+len(tp.WaitingChan) >= tp.Waiting
+}
+
+/**
+ *	Park a job in the backlog of the pool
+ */
+func (tp *TaskPool) PushBacklogJob(job TaskJob) {
+	job.Instance().SetWarning("parked in backlog, waiting queue of the pool is full")
+	tp.locker.Lock()
+	tp.backlog = append(tp.backlog, job)
+	tp.locker.Unlock()
+}
+
+/**
+ *	Move jobs from the backlog to the waiting queue while it has room, in submission order
+ *	Returns the number of jobs moved
+ */
+func (tp *TaskPool) FillFromBacklog() int {
+	tp.locker.Lock()
+	defer tp.locker.Unlock()
+	moved := 0
+	for len(tp.backlog) > 0 && tp.waitings.Len() < tp.Waiting {
+		job := tp.backlog[0]
+		tp.backlog = tp.backlog[1:]
+		job.Instance().SetWarning("")
+		tp.waitings.push(job)
+		moved++
+	}
+	return moved
+}
+
+/**
+ *	Remove a job from the backlog
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) removeBacklogJob(uuid string) bool {
+	for i, job := range tp.backlog {
+		if job.Instance().UUID == uuid {
+			tp.backlog = append(tp.backlog[:i], tp.backlog[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"fmt"
+	"time"
+)
+
+/**
+ * Outcome of a task when one of its upstream tasks does not succeed
+ */
+const (
+	UpstreamFailureCancel = "cancel" // Task ends as Cancelled
+	UpstreamFailureSkip   = "skip"   // Task ends as Skipped
+)
+
+/**
+ * Check the outcome on upstream failure
+ */
+func CheckUpstreamFailure(outcome string) error {
+	switch outcome {
+	case "", UpstreamFailureCancel, UpstreamFailureSkip:
+		return nil
+	default:
+		return fmt.Errorf("on_upstream_failure must be '%s' or '%s'", UpstreamFailureCancel, UpstreamFailureSkip)
+	}
+}
+
+/**
+ * Final status of the task when one of its upstream tasks does not succeed
+ */
+func (ti *TaskInstance) UpstreamFailureStatus() TaskStatus {
+	if ti.OnUpstreamFailure == UpstreamFailureSkip {
+		return TaskStatusSkipped
+	}
+	return TaskStatusCancelled
+}
+
+/**
+ * Hold the task out of the queue until its upstream tasks succeed
+ * @param upstreams []string Unfinished upstream tasks
+ */
+func (ti *TaskInstance) Pend(upstreams []string) {
+	warning := fmt.Sprintf("waiting for upstream tasks %v", upstreams)
+	if ti.transit(TaskStatusPending, warning, SourceScheduler, func() {
+		ti.Warning = warning
+		ti.phase = PhaseQueue
+	}) {
+		ti.Update()
+	}
+}
+
+/**
+ * Hold the task out of the queue until start_after
+ * Returns false if start_after has passed
+ */
+func (ti *TaskInstance) HoldUntilStart() bool {
+	if ti.StartAfter == nil || !ti.StartAfter.After(time.Now()) {
+		return false
+	}
+	warning := fmt.Sprintf("scheduled to start after %s", ti.StartAfter.Local().Format(time.DateTime))
+	if !ti.transit(TaskStatusScheduled, warning, SourceScheduler, func() {
+		ti.Warning = warning
+		ti.phase = PhaseQueue
+	}) {
+		return false
+	}
+	ti.Update()
+	return true
+}
@@ -0,0 +1,60 @@
+package task
+
+import (
+	"taskd/dao"
+	"time"
+)
+
+/**
+ * Sources of status transitions
+ */
+const (
+	SourceUser      = "user"      // API calls: submit, cancel, suspend, resume and move
+	SourceScheduler = "scheduler" // Pool scheduling: start, dependencies, start_after, re-queue and preemption
+	SourcePoller    = "poller"    // Status polled from the engine by the poller
+	SourceReactor   = "reactor"   // Status reported by the job to the reactor
+	SourceEngine    = "engine"    // Status set by the engine itself
+	SourceTimeout   = "timeout"   // Time limits
+	SourceRetry     = "retry"     // Retry policy
+)
+
+/**
+ * Max events kept for a task, the oldest ones are dropped
+ */
+const maxTaskEvents = 100
+
+/**
+ * Record the submission of the task as its first event
+ */
+func (ti *TaskInstance) addSubmitEvent(now time.Time) {
+	ti.appendEvent(dao.TaskEvent{
+		To:     string(TaskStatusQueue),
+		Time:   now,
+		Reason: "submitted",
+		Source: SourceUser,
+	})
+}
+
+/**
+ * Append an event to the log, keeping at most maxTaskEvents
+ * Transitions are appended by the state machine, the log is saved with the task record
+ */
+func (ti *TaskInstance) appendEvent(event dao.TaskEvent) {
+	ti.Events = append(ti.Events, event)
+	if n := len(ti.Events); n > maxTaskEvents {
+		ti.Events = ti.Events[n-maxTaskEvents:]
+	}
+}
+
+/**
+ * The latest status transition of the task
+ */
+func (ti *TaskInstance) LastEvent() *dao.TaskEvent {
+	ti.statusMutex.RLock()
+	defer ti.statusMutex.RUnlock()
+	if len(ti.Events) == 0 {
+		return nil
+	}
+	event := ti.Events[len(ti.Events)-1]
+	return &event
+}
@@ -1,6 +1,7 @@
 package task
 
 import (
+	"taskd/dao"
 	"time"
 )
 
@@ -13,6 +14,8 @@ type TaskInstanceSummary struct {
 	Status      string            `json:"status"`                 //task status
 	CreatedBy   string            `json:"created_by"`             //created by
 	Pool        string            `json:"pool"`                   //running pool
+	Priority    int               `json:"priority"`               //task priority
+	Position    int               `json:"position,omitempty"`     //position in waiting queue (1 is the head)
 	Warning     string            `json:"warning"`                //warning message
 	Error       string            `json:"error"`                  //error message
 	Tags        map[string]string `json:"tags"`                   //tags
@@ -20,14 +23,16 @@ type TaskInstanceSummary struct {
 	StartTime   *time.Time        `json:"start_time,omitempty"`   //start time
 	RunningTime *time.Time        `json:"running_time,omitempty"` //actual running start time
 	EndTime     *time.Time        `json:"end_time,omitempty"`     //end time
+	LastEvent   *dao.TaskEvent    `json:"last_event,omitempty"`   //latest status transition
 }
 
 func (ti *TaskInstance) GetSummary() TaskInstanceSummary {
 	return TaskInstanceSummary{
 		UUID:        ti.UUID,
 		Name:        ti.Name,
-		Status:      ti.Status,
+		Status:      string(ti.GetStatus()),
 		Pool:        ti.Pool,
+		Priority:    ti.Priority,
 		Warning:     ti.Warning,
 		Error:       ti.Error,
 		Tags:        ti.GetTags(),
@@ -36,5 +41,6 @@ func (ti *TaskInstance) GetSummary() TaskInstanceSummary {
 		StartTime:   ti.StartTime,
 		RunningTime: ti.RunningTime,
 		EndTime:     ti.EndTime,
+		LastEvent:   ti.LastEvent(),
 	}
 }
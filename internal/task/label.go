@@ -0,0 +1,253 @@
+package task
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"taskd/dao"
+	"taskd/internal/utils"
+)
+
+/**
+ *	Parse pool labels, e.g: "gpu=a800,region=sh,tier=prod"
+ */
+func ParseLabels(labels string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, item := range strings.Split(labels, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(item, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label [%s], should be key=value", item)
+		}
+		result[k] = v
+	}
+	return result, nil
+}
+
+/**
+ *	A requirement on pool labels
+ */
+type selectorTerm struct {
+	key    string
+	value  string
+	negate bool // Label must not equal value, or must not exist if value is empty
+	exists bool // Label must exist with any value
+}
+
+/**
+ *	Selector of pool labels, terms are separated by commas and all must match:
+ *	"gpu=a800" label equals, "tier!=dev" label differs or is absent,
+ *	"gpu" label exists, "!spot" label does not exist
+ */
+type PoolSelector []selectorTerm
+
+/**
+ *	Parse pool selector
+ */
+func ParsePoolSelector(selector string) (PoolSelector, error) {
+	var result PoolSelector
+	for _, item := range strings.Split(selector, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var term selectorTerm
+		if k, v, ok := strings.Cut(item, "!="); ok {
+			term = selectorTerm{key: strings.TrimSpace(k), value: strings.TrimSpace(v), negate: true}
+		} else if k, v, ok := strings.Cut(item, "="); ok {
+			term = selectorTerm{key: strings.TrimSpace(k), value: strings.TrimSpace(v)}
+		} else if strings.HasPrefix(item, "!") {
+			term = selectorTerm{key: strings.TrimSpace(item[1:]), negate: true}
+		} else {
+			term = selectorTerm{key: item, exists: true}
+		}
+		if term.key == "" {
+			return nil, fmt.Errorf("invalid pool selector [%s]", item)
+		}
+		result = append(result, term)
+	}
+	return result, nil
+}
+
+/**
+ *	Check if labels satisfy all terms of the selector, an empty selector matches any labels
+ */
+func (s PoolSelector) Matches(labels map[string]string) bool {
+	for _, term := range s {
+		value, ok := labels[term.key]
+		switch {
+		case term.exists:
+			if !ok {
+				return false
+			}
+		case term.negate:
+			if ok && (term.value == "" || value == term.value) {
+				return false
+			}
+		default:
+			if !ok || value != term.value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/**
+ *	Readable form of the selector
+ */
+func (s PoolSelector) String() string {
+	items := make([]string, 0, len(s))
+	for _, term := range s {
+		switch {
+		case term.exists:
+			items = append(items, term.key)
+		case term.negate && term.value == "":
+			items = append(items, "!"+term.key)
+		case term.negate:
+			items = append(items, term.key+"!="+term.value)
+		default:
+			items = append(items, term.key+"="+term.value)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+/**
+ *	A parsed pool preference
+ */
+type poolPreference struct {
+	selector PoolSelector
+	weight   int
+}
+
+/**
+ *	Pool selection requirements of the task
+ *	Task fields take precedence, otherwise "pool_selector" in template.extra is used
+ */
+func (ti *TaskInstance) GetPoolSelector() (PoolSelector, error) {
+	selector := ti.PoolSelector
+	if selector == "" && ti.template != nil && ti.template.Extra != "" {
+		if extra, err := ParseArgs(ti.template.Extra); err == nil {
+			selector = GetArgString(extra, "pool_selector", "")
+		}
+	}
+	return ParsePoolSelector(selector)
+}
+
+/**
+ *	Preference weight of the pool labels: sum of weights of matched preferences
+ */
+func (ti *TaskInstance) PreferenceScore(labels map[string]string) int {
+	score := 0
+	for _, pref := range ti.getPoolPreferences() {
+		if pref.selector.Matches(labels) {
+			score += pref.weight
+		}
+	}
+	return score
+}
+
+/**
+ *	Parsed pool preferences of the task, invalid ones are ignored
+ */
+func (ti *TaskInstance) getPoolPreferences() []poolPreference {
+	var result []poolPreference
+	for _, p := range ti.PoolPreferences {
+		selector, err := ParsePoolSelector(p.Selector)
+		if err != nil {
+			utils.Errorf("Task [%s] pool preference is ignored: %v", ti.Title(), err)
+			continue
+		}
+		result = append(result, poolPreference{selector: selector, weight: p.Weight})
+	}
+	return result
+}
+
+/**
+ *	Check pool selector and preferences of a task object
+ */
+func CheckPoolSelector(to *dao.TaskObjRec) error {
+	if _, err := ParsePoolSelector(to.PoolSelector); err != nil {
+		return err
+	}
+	for _, p := range to.PoolPreferences {
+		if _, err := ParsePoolSelector(p.Selector); err != nil {
+			return err
+		}
+		if p.Weight <= 0 {
+			return fmt.Errorf("weight of pool preference [%s] must be positive", p.Selector)
+		}
+	}
+	return nil
+}
+
+/**
+ *	Labels of the pool
+ */
+func (tp *TaskPool) GetLabels() map[string]string {
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	return tp.labels
+}
+
+/**
+ *	Parse labels of the pool, invalid labels are ignored
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) parseLabels() {
+	labels, err := ParseLabels(tp.Labels)
+	if err != nil {
+		utils.Errorf("Pool [%s] labels are ignored: %v", tp.PoolId, err)
+		labels = map[string]string{}
+	}
+	tp.labels = labels
+}
+
+/**
+ *	Readable labels in sorted order, e.g: gpu=a800,region=sh
+ */
+func formatLabels(labels map[string]string) string {
+	items := make([]string, 0, len(labels))
+	for k, v := range labels {
+		items = append(items, k+"="+v)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+/**
+ *	Fraction of configured resources left in the pool after the quotas are taken,
+ *	the smallest among the resources requested, 1 if none of them is configured
+ */
+func (tp *TaskPool) FreeResourceRatio(quotas []dao.Quota) float64 {
+	tp.resLocker.Lock()
+	defer tp.resLocker.Unlock()
+
+	ratio := 1.0
+	for _, q := range quotas {
+		rc, ok := tp.resources[q.ResName]
+		if !ok {
+			continue
+		}
+		remain := rc.Capacity
+		if err := remain.Minus(rc.Allocate); err != nil {
+			continue
+		}
+		if err := remain.Minus(utils.Quantity{Amend: q.ResNum, Unit: q.ResFmt}); err != nil {
+			continue
+		}
+		capacity := rc.Capacity
+		if err := capacity.ChangeUnit(remain.Unit); err != nil || capacity.Amend <= 0 {
+			continue
+		}
+		if r := float64(remain.Amend) / float64(capacity.Amend); r < ratio {
+			ratio = r
+		}
+	}
+	return ratio
+}
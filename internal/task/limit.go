@@ -0,0 +1,152 @@
+package task
+
+import (
+	"fmt"
+	"sort"
+)
+
+/**
+ *	Dimension of a concurrency limit
+ */
+const (
+	LimitByTemplate = "template" // Limit running tasks of a template
+	LimitByProject  = "project"  // Limit running tasks of a project
+	LimitByOwner    = "owner"    // Limit running tasks of a creator
+)
+
+/**
+ *	Concurrency limit inside a pool, e.g:
+ *	{"by": "template", "value": "model_image_build", "max": 2}: at most 2 running model_image_build tasks
+ *	{"by": "project", "max": 8}: at most 8 running tasks per project
+ */
+type ConcurrencyLimit struct {
+	By    string `json:"by"`              // Dimension: template, project or owner
+	Value string `json:"value,omitempty"` // Limited template/project/owner, the limit applies to each one if empty
+	Max   int    `json:"max"`             // Max running tasks
+}
+
+/**
+ *	Current usage of a concurrency limit
+ */
+type LimitUsage struct {
+	ConcurrencyLimit
+	Running int `json:"running"` // Running tasks counted by the limit
+	Waiting int `json:"waiting"` // Waiting tasks blocked by the limit
+}
+
+/**
+ *	Check concurrency limit settings
+ */
+func (l *ConcurrencyLimit) check() error {
+	if l.By != LimitByTemplate && l.By != LimitByProject && l.By != LimitByOwner {
+		return fmt.Errorf("limit by must be '%s', '%s' or '%s'", LimitByTemplate, LimitByProject, LimitByOwner)
+	}
+	if l.Max <= 0 {
+		return fmt.Errorf("max of limit [%s] must be positive", l.String())
+	}
+	return nil
+}
+
+/**
+ *	Readable description, e.g: template=model_image_build<=2, project=*<=8
+ */
+func (l *ConcurrencyLimit) String() string {
+	value := l.Value
+	if value == "" {
+		value = "*"
+	}
+	return fmt.Sprintf("%s=%s<=%d", l.By, value, l.Max)
+}
+
+/**
+ *	Value of the task in the limit dimension
+ */
+func (l *ConcurrencyLimit) key(ti *TaskInstance) string {
+	switch l.By {
+	case LimitByTemplate:
+		return ti.Template
+	case LimitByProject:
+		return ti.Project
+	default:
+		return ti.CreatedBy
+	}
+}
+
+/**
+ *	Check if the limit applies to the task
+ */
+func (l *ConcurrencyLimit) matches(ti *TaskInstance) bool {
+	return l.Value == "" || l.Value == l.key(ti)
+}
+
+/**
+ *	Count running tasks sharing the limit with the task
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) countLimited(l *ConcurrencyLimit, ti *TaskInstance) int {
+	key := l.key(ti)
+	count := 0
+	for _, job := range tp.runnings {
+		if l.key(job.Instance()) == key {
+			count++
+		}
+	}
+	return count
+}
+
+/**
+ *	Find the concurrency limit reached by the task, nil if the task may start
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) reachedLimit(ti *TaskInstance) *ConcurrencyLimit {
+	for i := range tp.policy.Limits {
+		l := &tp.policy.Limits[i]
+		if l.matches(ti) && tp.countLimited(l, ti) >= l.Max {
+			return l
+		}
+	}
+	return nil
+}
+
+/**
+ *	Usage of each concurrency limit
+ *	A limit without value is reported for each template/project/owner it applies to
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) getLimitUsages() []LimitUsage {
+	var result []LimitUsage
+	for _, l := range tp.policy.Limits {
+		usages := make(map[string]*LimitUsage)
+		get := func(key string) *LimitUsage {
+			if u, ok := usages[key]; ok {
+				return u
+			}
+			u := &LimitUsage{ConcurrencyLimit: l}
+			u.Value = key
+			usages[key] = u
+			return u
+		}
+		if l.Value != "" {
+			get(l.Value)
+		}
+		for _, job := range tp.runnings {
+			if ti := job.Instance(); l.matches(ti) {
+				get(l.key(ti)).Running++
+			}
+		}
+		for _, item := range tp.waitings.items {
+			if u, ok := usages[l.key(item.job.Instance())]; ok && u.Running >= u.Max {
+				u.Waiting++
+			}
+		}
+		keys := make([]string, 0, len(usages))
+		for key := range usages {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			result = append(result, *usages[key])
+		}
+	}
+	return result
+}
@@ -0,0 +1,222 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+/**
+ *	Tenant dimension used by fair-share scheduling
+ */
+const (
+	FairShareByProject = "project" // Share pool between projects
+	FairShareByOwner   = "owner"   // Share pool between task creators
+)
+
+/**
+ *	Fair-share settings of a pool
+ *	Next waiting task is taken from the tenant with the least usage relative to its weight
+ */
+type FairShare struct {
+	By      string         `json:"by"`                // Tenant dimension: project or owner
+	Weights map[string]int `json:"weights,omitempty"` // Weight of each tenant, tenants not listed weigh 1
+}
+
+/**
+ *	Scheduling policy of a pool, stored as JSON in dao.Pool.Policy
+ */
+type PoolPolicy struct {
+	FairShare    *FairShare         `json:"fair_share,omitempty"`   // Fair-share scheduling between tenants
+	Limits       []ConcurrencyLimit `json:"limits,omitempty"`       // Concurrency limits per template, project or owner
+	Backpressure *Backpressure      `json:"backpressure,omitempty"` // Handling of submissions when the waiting queue is full
+}
+
+/**
+ *	Usage of a tenant in the pool
+ */
+type TenantUsage struct {
+	Tenant  string  `json:"tenant"`   //project or owner
+	Weight  int     `json:"weight"`   //configured weight
+	Waiting int     `json:"waiting"`  //tasks currently waiting
+	Running int     `json:"running"`  //tasks currently running
+	Started int     `json:"started"`  //tasks started since the pool was loaded
+	RunTime float64 `json:"run_time"` //seconds used by finished tasks since the pool was loaded
+}
+
+/**
+ *	Usage accounting of a tenant
+ */
+type tenantUsage struct {
+	running int
+	started int
+	runTime time.Duration
+}
+
+/**
+ *	Parse pool policy from JSON
+ */
+func ParsePoolPolicy(policy string) (PoolPolicy, error) {
+	var result PoolPolicy
+	if policy == "" {
+		return result, nil
+	}
+	if err := json.Unmarshal([]byte(policy), &result); err != nil {
+		return result, fmt.Errorf("invalid pool policy: %v", err)
+	}
+	if fs := result.FairShare; fs != nil {
+		if fs.By != FairShareByProject && fs.By != FairShareByOwner {
+			return result, fmt.Errorf("invalid pool policy: fair_share.by must be '%s' or '%s'",
+				FairShareByProject, FairShareByOwner)
+		}
+		for tenant, w := range fs.Weights {
+			if w <= 0 {
+				return result, fmt.Errorf("invalid pool policy: weight of [%s] must be positive", tenant)
+			}
+		}
+	}
+	for i := range result.Limits {
+		if err := result.Limits[i].check(); err != nil {
+			return result, fmt.Errorf("invalid pool policy: %v", err)
+		}
+	}
+	if bp := result.Backpressure; bp != nil {
+		if err := bp.check(); err != nil {
+			return result, fmt.Errorf("invalid pool policy: %v", err)
+		}
+	}
+	return result, nil
+}
+
+/**
+ *	Tenant of the task according to fair-share dimension
+ */
+func (fs *FairShare) tenant(ti *TaskInstance) string {
+	if fs.By == FairShareByOwner {
+		return ti.CreatedBy
+	}
+	return ti.Project
+}
+
+/**
+ *	Weight of the tenant
+ */
+func (fs *FairShare) weight(tenant string) int {
+	if w, ok := fs.Weights[tenant]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+/**
+ *	Order jobs for dequeue under fair-share: higher priority first, then the tenant
+ *	with the least running tasks per weight, then the least historical run time per weight.
+ *	Jobs must be sorted in dequeue order, FIFO order inside a tenant is kept.
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) fairShareOrder(jobs []TaskJob) []TaskJob {
+	fs := tp.policy.FairShare
+	if fs == nil {
+		return jobs
+	}
+	share := func(job TaskJob) (float64, float64) {
+		tenant := fs.tenant(job.Instance())
+		weight := float64(fs.weight(tenant))
+		u := tp.tenants[tenant]
+		if u == nil {
+			return 0, 0
+		}
+		return float64(u.running) / weight, u.runTime.Seconds() / weight
+	}
+	sort.SliceStable(jobs, func(i, j int) bool {
+		pi, pj := jobs[i].Instance().Priority, jobs[j].Instance().Priority
+		if pi != pj {
+			return pi > pj
+		}
+		ri, hi := share(jobs[i])
+		rj, hj := share(jobs[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return hi < hj
+	})
+	return jobs
+}
+
+/**
+ *	Account a task starting to run
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) chargeTenant(ti *TaskInstance) {
+	fs := tp.policy.FairShare
+	if fs == nil {
+		return
+	}
+	tenant := fs.tenant(ti)
+	u := tp.tenants[tenant]
+	if u == nil {
+		u = &tenantUsage{}
+		tp.tenants[tenant] = u
+	}
+	u.running++
+	u.started++
+}
+
+/**
+ *	Account a task leaving the running table
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) refundTenant(ti *TaskInstance) {
+	fs := tp.policy.FairShare
+	if fs == nil {
+		return
+	}
+	u := tp.tenants[fs.tenant(ti)]
+	if u == nil {
+		return
+	}
+	if u.running > 0 {
+		u.running--
+	}
+	if ti.StartTime != nil {
+		u.runTime += time.Since(*ti.StartTime)
+	}
+}
+
+/**
+ *	Current and historical usage of each tenant
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) getTenantUsages() []TenantUsage {
+	fs := tp.policy.FairShare
+	if fs == nil {
+		return nil
+	}
+	usages := make(map[string]*TenantUsage)
+	get := func(tenant string) *TenantUsage {
+		if u, ok := usages[tenant]; ok {
+			return u
+		}
+		u := &TenantUsage{Tenant: tenant, Weight: fs.weight(tenant)}
+		usages[tenant] = u
+		return u
+	}
+	for tenant, tu := range tp.tenants {
+		u := get(tenant)
+		u.Running = tu.running
+		u.Started = tu.started
+		u.RunTime = tu.runTime.Seconds()
+	}
+	for _, item := range tp.waitings.items {
+		get(fs.tenant(item.job.Instance())).Waiting++
+	}
+	var result []TenantUsage
+	for _, u := range usages {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Tenant < result[j].Tenant
+	})
+	return result
+}
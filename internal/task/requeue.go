@@ -0,0 +1,53 @@
+package task
+
+import "time"
+
+/**
+ * Default max times a task failing in Init phase is put back to the queue,
+ * can be modified by system configuration
+ */
+var defaultRequeueLimit = 3
+
+/**
+ *	Set default re-queue limit, 0 keeps the built-in default, negative disables re-queue
+ */
+func SetDefaultRequeueLimit(limit int) {
+	if limit > 0 {
+		defaultRequeueLimit = limit
+	} else if limit < 0 {
+		defaultRequeueLimit = 0
+	}
+}
+
+/**
+ * Max times the task is put back to the queue after failing in Init phase
+ * Set by "requeue_limit" in extra, 0 disables re-queue
+ */
+func (ti *TaskInstance) GetRequeueLimit() int {
+	if ti.template == nil {
+		return defaultRequeueLimit
+	}
+	extra, err := ti.GetExtra()
+	if err != nil {
+		return defaultRequeueLimit
+	}
+	return GetArgNumber(extra, "requeue_limit", defaultRequeueLimit)
+}
+
+/**
+ * Check if the task failed in Init phase and may be put back to the queue
+ * Tasks cancelled by user or killed for exceeding the whole limit are never re-queued
+ */
+func (ti *TaskInstance) Requeueable() bool {
+	if ti.phase != PhaseInit {
+		return false
+	}
+	status := ti.GetStatus()
+	if status != TaskStatusFailed && status != TaskStatusKilled {
+		return false
+	}
+	if status == TaskStatusKilled && ti.wholeDeadline().Exceeded(time.Now()) {
+		return false
+	}
+	return ti.Requeues < ti.GetRequeueLimit()
+}
@@ -0,0 +1,162 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"taskd/dao"
+	"taskd/internal/utils"
+	"time"
+)
+
+/**
+ * Retry policy of a task
+ * Defined by task_obj.retry (JSON), or by "retry" object in template.extra
+ */
+type RetryPolicy struct {
+	MaxAttempts int      `json:"max_attempts,omitempty"` // Max attempts including the first run, 0 or 1 disables retry
+	Backoff     int      `json:"backoff,omitempty"`      // Delay before the first retry in seconds
+	Factor      float64  `json:"factor,omitempty"`       // Delay multiplier between retries, default 2
+	MaxBackoff  int      `json:"max_backoff,omitempty"`  // Upper bound of the delay in seconds, default 1 hour
+	Statuses    []string `json:"statuses,omitempty"`     // Retryable statuses, default Failed
+	Errors      []string `json:"errors,omitempty"`       // Retryable error patterns (regexp), any error is retryable if empty
+}
+
+/**
+ * Default retry settings
+ */
+const (
+	defaultRetryFactor     = 2.0
+	defaultRetryMaxBackoff = time.Hour
+)
+
+/**
+ * Parse retry policy from JSON
+ */
+func ParseRetryPolicy(policy string) (RetryPolicy, error) {
+	var result RetryPolicy
+	if policy == "" {
+		return result, nil
+	}
+	if err := json.Unmarshal([]byte(policy), &result); err != nil {
+		return result, fmt.Errorf("invalid retry policy: %v", err)
+	}
+	for _, s := range result.Statuses {
+		if !TaskStatus(s).IsFinished() {
+			return result, fmt.Errorf("invalid retry policy: status [%s] is not a finished status", s)
+		}
+	}
+	for _, e := range result.Errors {
+		if _, err := regexp.Compile(e); err != nil {
+			return result, fmt.Errorf("invalid retry policy: error pattern [%s]: %v", e, err)
+		}
+	}
+	return result, nil
+}
+
+/**
+ * Check if a task ending with status and errMsg in the specified attempt (from 1) should run again
+ */
+func (p RetryPolicy) Retryable(attempt int, status TaskStatus, errMsg string) bool {
+	// Never go against the user or the task graph
+	if attempt >= p.MaxAttempts || status == TaskStatusCancelled || status == TaskStatusSkipped {
+		return false
+	}
+	statuses := p.Statuses
+	if len(statuses) == 0 {
+		statuses = []string{string(TaskStatusFailed)}
+	}
+	matched := false
+	for _, s := range statuses {
+		if TaskStatus(s) == status {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return false
+	}
+	if len(p.Errors) == 0 {
+		return true
+	}
+	for _, e := range p.Errors {
+		if re, err := regexp.Compile(e); err == nil && re.MatchString(errMsg) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Delay before running again after the specified attempt (from 1) ended
+ * Grows exponentially: backoff * factor^(attempt-1), bounded by max_backoff
+ */
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	factor := p.Factor
+	if factor < 1 {
+		factor = defaultRetryFactor
+	}
+	maxBackoff := defaultRetryMaxBackoff
+	if p.MaxBackoff > 0 {
+		maxBackoff = time.Duration(p.MaxBackoff) * time.Second
+	}
+	delay := time.Duration(p.Backoff) * time.Second
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay = time.Duration(float64(delay) * factor)
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+/**
+ * Get retry policy of the task
+ * task_obj.retry takes precedence over "retry" in template.extra
+ */
+func (ti *TaskInstance) GetRetryPolicy() RetryPolicy {
+	var policy RetryPolicy
+	var err error
+	if ti.Retry != "" {
+		if policy, err = ParseRetryPolicy(ti.Retry); err != nil {
+			utils.Errorf("Task [%s] parse 'retry' failed: %v", ti.Title(), err)
+		}
+		return policy
+	}
+	if ti.template == nil || ti.template.Extra == "" {
+		return policy
+	}
+	extra, err := ParseArgs(ti.template.Extra)
+	if err != nil {
+		return policy
+	}
+	v, ok := extra["retry"]
+	if !ok {
+		return policy
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return policy
+	}
+	if policy, err = ParseRetryPolicy(string(data)); err != nil {
+		utils.Errorf("Task [%s] parse 'template.extra.retry' failed: %v", ti.Title(), err)
+	}
+	return policy
+}
+
+/**
+ * Record the attempt that just ended in the task's attempt history
+ */
+func (ti *TaskInstance) AddAttempt() {
+	endTime := ti.EndTime
+	if endTime == nil {
+		now := time.Now().Local()
+		endTime = &now
+	}
+	ti.Attempts = append(ti.Attempts, dao.Attempt{
+		StartTime: ti.StartTime,
+		EndTime:   endTime,
+		Status:    string(ti.GetStatus()),
+		Error:     ti.Error,
+	})
+}
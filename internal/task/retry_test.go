@@ -0,0 +1,59 @@
+package task
+
+import (
+	"taskd/dao"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Retryable(t *testing.T) {
+	policy, err := ParseRetryPolicy(`{"max_attempts": 3, "statuses": ["Failed", "Killed"], "errors": ["kubectl apply", "timeout"]}`)
+	if err != nil {
+		t.Fatalf("ParseRetryPolicy() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		attempt int
+		status  TaskStatus
+		errMsg  string
+		want    bool
+	}{
+		{"apply failed", 1, TaskStatusFailed, "exec kubectl apply failed", true},
+		{"killed by timeout", 2, TaskStatusKilled, "Init phase timeout", true},
+		{"last attempt", 3, TaskStatusFailed, "exec kubectl apply failed", false},
+		{"unmatched error", 1, TaskStatusFailed, "exit code 1", false},
+		{"unmatched status", 1, TaskStatusSucceeded, "", false},
+		{"cancelled", 1, TaskStatusCancelled, "timeout", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.Retryable(tt.attempt, tt.status, tt.errMsg); got != tt.want {
+				t.Errorf("Retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if (RetryPolicy{}).Retryable(1, TaskStatusFailed, "") {
+		t.Errorf("Retryable() of empty policy should be false")
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, Backoff: 10, MaxBackoff: 60}
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 60 * time.Second, 60 * time.Second}
+	for i, w := range want {
+		if got := policy.Delay(i + 1); got != w {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestTaskInstance_GetRetryPolicy(t *testing.T) {
+	ti := &TaskInstance{template: &dao.TemplateRec{Extra: `{"retry": {"max_attempts": 2, "backoff": 5}}`}}
+	if p := ti.GetRetryPolicy(); p.MaxAttempts != 2 || p.Backoff != 5 {
+		t.Errorf("GetRetryPolicy() from template = %+v", p)
+	}
+	ti.Retry = `{"max_attempts": 4}`
+	if p := ti.GetRetryPolicy(); p.MaxAttempts != 4 || p.Backoff != 0 {
+		t.Errorf("GetRetryPolicy() from task = %+v", p)
+	}
+}
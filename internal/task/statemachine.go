@@ -0,0 +1,177 @@
+package task
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"taskd/dao"
+	"taskd/internal/utils"
+	"time"
+)
+
+/**
+ * Hook fired when a task exits or enters a status, with the transition being made
+ */
+type StatusHook func(ti *TaskInstance, event dao.TaskEvent)
+
+/**
+ * State machine of task status
+ * Every status change of a task goes through it: illegal transitions are rejected,
+ * legal ones are recorded in the event log and fire the exit hooks of the old status and the entry hooks of the new one
+ */
+type stateMachine struct {
+	transitions map[TaskStatus][]TaskStatus // Legal targets of each unfinished status
+	reopens     []TaskStatus                // Finished statuses that may be queued again
+	reopeners   []string                    // Sources that may queue a finished task again
+	marks       map[TaskStatus]StatusHook   // Built-in entry hooks, applied with the transition
+	enter       map[TaskStatus][]StatusHook
+	exit        map[TaskStatus][]StatusHook
+	mutex       sync.RWMutex
+}
+
+/**
+ * Task status state machine
+ * Queue-phase statuses move among each other and to Init, started tasks move forward or back to the queue
+ * by preemption and suspension only, and a finished task can only be queued again by the scheduler
+ * (failed in Init phase) or its retry policy; Cancelled and Skipped are terminal
+ */
+var statusMachine = &stateMachine{
+	transitions: map[TaskStatus][]TaskStatus{
+		TaskStatusQueue: {TaskStatusPending, TaskStatusScheduled, TaskStatusInit, TaskStatusSuspended,
+			TaskStatusFailed, TaskStatusCancelled, TaskStatusKilled, TaskStatusSkipped},
+		TaskStatusPreempted: {TaskStatusInit, TaskStatusSuspended,
+			TaskStatusFailed, TaskStatusCancelled, TaskStatusKilled},
+		TaskStatusPending: {TaskStatusQueue,
+			TaskStatusFailed, TaskStatusCancelled, TaskStatusKilled, TaskStatusSkipped},
+		TaskStatusScheduled: {TaskStatusQueue, TaskStatusPending,
+			TaskStatusFailed, TaskStatusCancelled, TaskStatusKilled, TaskStatusSkipped},
+		TaskStatusSuspended: {TaskStatusQueue,
+			TaskStatusFailed, TaskStatusCancelled, TaskStatusKilled},
+		TaskStatusInit: {TaskStatusRunning, TaskStatusPreempted, TaskStatusSuspended,
+			TaskStatusSucceeded, TaskStatusFailed, TaskStatusCancelled, TaskStatusKilled},
+		TaskStatusRunning: {TaskStatusPreempted, TaskStatusSuspended,
+			TaskStatusSucceeded, TaskStatusFailed, TaskStatusCancelled, TaskStatusKilled},
+	},
+	reopens:   []TaskStatus{TaskStatusSucceeded, TaskStatusFailed, TaskStatusKilled},
+	reopeners: []string{SourceScheduler, SourceRetry},
+	marks: map[TaskStatus]StatusHook{
+		TaskStatusInit:      markStarted,
+		TaskStatusRunning:   markRunning,
+		TaskStatusSucceeded: markEnded,
+		TaskStatusFailed:    markEnded,
+		TaskStatusCancelled: markEnded,
+		TaskStatusKilled:    markEnded,
+		TaskStatusSkipped:   markEnded,
+	},
+	enter: map[TaskStatus][]StatusHook{},
+	exit:  map[TaskStatus][]StatusHook{},
+}
+
+/**
+ * Check if a task may change from one status to another by source
+ * Staying in the same status is always allowed
+ */
+func CheckTransition(from TaskStatus, to TaskStatus, source string) error {
+	return statusMachine.check(from, to, source)
+}
+
+/**
+ * Register a hook fired when a task enters one of the statuses
+ * Hooks run in the goroutine changing the status after the transition is made, they must not block
+ * The task may have moved on by then, the event tells the transition the hook is fired for
+ */
+func OnStatusEnter(hook StatusHook, statuses ...TaskStatus) {
+	statusMachine.mutex.Lock()
+	defer statusMachine.mutex.Unlock()
+	for _, s := range statuses {
+		statusMachine.enter[s] = append(statusMachine.enter[s], hook)
+	}
+}
+
+/**
+ * Register a hook fired when a task exits one of the statuses
+ */
+func OnStatusExit(hook StatusHook, statuses ...TaskStatus) {
+	statusMachine.mutex.Lock()
+	defer statusMachine.mutex.Unlock()
+	for _, s := range statuses {
+		statusMachine.exit[s] = append(statusMachine.exit[s], hook)
+	}
+}
+
+func (sm *stateMachine) check(from TaskStatus, to TaskStatus, source string) error {
+	if from == to {
+		return nil
+	}
+	if from.IsFinished() {
+		if to == TaskStatusQueue && slices.Contains(sm.reopens, from) && slices.Contains(sm.reopeners, source) {
+			return nil
+		}
+	} else if slices.Contains(sm.transitions[from], to) {
+		return nil
+	}
+	return fmt.Errorf("illegal status transition from %s to %s by %s", from, to, source)
+}
+
+/**
+ * Change the status of the task through the state machine
+ * The check, the event and the fields updated by apply change together under the status lock of the task,
+ * exit hooks of the old status and entry hooks of the new one are fired after it is released
+ * Returns false, leaving the task untouched, if the transition is illegal
+ */
+func (ti *TaskInstance) transit(to TaskStatus, reason string, source string, apply func()) bool {
+	ti.statusMutex.Lock()
+	from := TaskStatus(ti.Status)
+	if err := statusMachine.check(from, to, source); err != nil {
+		ti.statusMutex.Unlock()
+		utils.Errorf("Task [%s] %v", ti.Title(), err)
+		return false
+	}
+	now := time.Now().Local()
+	ti.UpdateTime = &now
+	if from == to {
+		apply()
+		ti.statusMutex.Unlock()
+		return true
+	}
+	event := dao.TaskEvent{
+		From:   string(from),
+		To:     string(to),
+		Time:   now,
+		Reason: reason,
+		Source: source,
+	}
+	statusMachine.mutex.RLock()
+	mark, exit, enter := statusMachine.marks[to], statusMachine.exit[from], statusMachine.enter[to]
+	statusMachine.mutex.RUnlock()
+	ti.appendEvent(event)
+	ti.Status = string(to)
+	apply()
+	if mark != nil {
+		mark(ti, event)
+	}
+	ti.statusMutex.Unlock()
+
+	for _, hook := range exit {
+		hook(ti, event)
+	}
+	for _, hook := range enter {
+		hook(ti, event)
+	}
+	return true
+}
+
+/**
+ * Built-in entry hooks recording when the task reaches each phase, applied under the status lock
+ */
+func markStarted(ti *TaskInstance, event dao.TaskEvent) {
+	ti.StartTime = &event.Time
+}
+
+func markRunning(ti *TaskInstance, event dao.TaskEvent) {
+	ti.RunningTime = &event.Time
+}
+
+func markEnded(ti *TaskInstance, event dao.TaskEvent) {
+	ti.EndTime = &event.Time
+}
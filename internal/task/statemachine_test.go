@@ -0,0 +1,114 @@
+package task
+
+import (
+	"errors"
+	"sync"
+	"taskd/dao"
+	"testing"
+)
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   TaskStatus
+		to     TaskStatus
+		source string
+		legal  bool
+	}{
+		{"start", TaskStatusQueue, TaskStatusInit, SourceScheduler, true},
+		{"start preempted", TaskStatusPreempted, TaskStatusInit, SourceScheduler, true},
+		{"unchanged", TaskStatusRunning, TaskStatusRunning, SourcePoller, true},
+		{"running", TaskStatusInit, TaskStatusRunning, SourcePoller, true},
+		{"preempt", TaskStatusRunning, TaskStatusPreempted, SourceScheduler, true},
+		{"suspend", TaskStatusRunning, TaskStatusSuspended, SourceUser, true},
+		{"resume", TaskStatusSuspended, TaskStatusQueue, SourceUser, true},
+		{"upstream failed", TaskStatusPending, TaskStatusSkipped, SourceScheduler, true},
+		{"re-queue after Init failure", TaskStatusFailed, TaskStatusQueue, SourceScheduler, true},
+		{"retry", TaskStatusKilled, TaskStatusQueue, SourceRetry, true},
+		{"running back to queue", TaskStatusRunning, TaskStatusQueue, SourcePoller, false},
+		{"running back to init", TaskStatusRunning, TaskStatusInit, SourceReactor, false},
+		{"pending starts", TaskStatusPending, TaskStatusInit, SourceScheduler, false},
+		{"failed reopened by engine", TaskStatusFailed, TaskStatusQueue, SourceEngine, false},
+		{"cancelled retried", TaskStatusCancelled, TaskStatusQueue, SourceRetry, false},
+		{"succeeded then cancelled", TaskStatusSucceeded, TaskStatusCancelled, SourceUser, false},
+		{"not submitted", "", TaskStatusRunning, SourcePoller, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckTransition(tt.from, tt.to, tt.source); (err == nil) != tt.legal {
+				t.Errorf("CheckTransition(%s, %s, %s) error = %v, want legal %v", tt.from, tt.to, tt.source, err, tt.legal)
+			}
+		})
+	}
+}
+
+func TestTaskInstance_Transit(t *testing.T) {
+	var hooked []string
+	record := func(ti *TaskInstance, event dao.TaskEvent) {
+		if ti.UUID == "transit" {
+			hooked = append(hooked, event.From+"->"+event.To)
+		}
+	}
+	OnStatusExit(record, TaskStatusInit)
+	OnStatusEnter(record, TaskStatusKilled)
+
+	ti := &TaskInstance{}
+	ti.UUID = "transit"
+	ti.Status = string(TaskStatusInit)
+	ti.phase = PhaseInit
+
+	// Engine reports the task back in the queue, it is ignored
+	ti.SetStatus(TaskStatusQueue)
+	if ti.GetStatus() != TaskStatusInit || len(ti.Events) != 0 {
+		t.Fatalf("status = %s, events = %+v after illegal transition", ti.GetStatus(), ti.Events)
+	}
+
+	if !ti.Finish(TaskStatusKilled, errors.New("Init phase execution exceeded limit"), SourceTimeout) {
+		t.Fatalf("Finish() refused Init -> Killed")
+	}
+	if ti.Phase() != PhaseInit || ti.EndTime == nil || ti.UpdateTime == nil {
+		t.Errorf("phase = %v, end time = %v, want Init phase kept and end time set", ti.Phase(), ti.EndTime)
+	}
+	if len(hooked) != 2 || hooked[0] != "Init->Killed" || hooked[1] != "Init->Killed" {
+		t.Errorf("hooks = %v, want exit of Init and entry of Killed", hooked)
+	}
+
+	if ti.Finish(TaskStatusCancelled, nil, SourceUser) {
+		t.Errorf("Finish() changed a finished task")
+	}
+	if ti.GetStatus() != TaskStatusKilled || len(ti.Events) != 1 {
+		t.Errorf("status = %s, events = %+v, want Killed with one event", ti.GetStatus(), ti.Events)
+	}
+}
+
+func TestTaskInstance_TransitConcurrent(t *testing.T) {
+	ti := &TaskInstance{}
+	ti.UUID = "concurrent"
+	ti.Status = string(TaskStatusRunning)
+	ti.phase = PhaseRunning
+
+	// Only one of the racing stops ends the task
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	ended := 0
+	for _, status := range []TaskStatus{TaskStatusKilled, TaskStatusCancelled, TaskStatusSucceeded, TaskStatusFailed} {
+		wg.Add(2)
+		go func(status TaskStatus) {
+			defer wg.Done()
+			if ti.Finish(status, nil, SourceUser) {
+				mutex.Lock()
+				ended++
+				mutex.Unlock()
+			}
+		}(status)
+		go func() {
+			defer wg.Done()
+			ti.GetStatus()
+			ti.LastEvent()
+		}()
+	}
+	wg.Wait()
+	if ended != 1 || len(ti.Events) != 1 || !ti.GetStatus().IsFinished() {
+		t.Errorf("ended = %d, events = %+v, status = %s, want a single transition", ended, ti.Events, ti.GetStatus())
+	}
+}
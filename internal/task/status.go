@@ -22,6 +22,7 @@ type Timeout struct {
 	Init    time.Duration `json:"init,omitempty"`    // Max time from dispatch to initialization complete
 	Running time.Duration `json:"running,omitempty"` // Max time from initialization complete to task completion
 	Whole   time.Duration `json:"whole,omitempty"`   // Max time from submission to completion (entire workflow)
+	Warn    int           `json:"warn,omitempty"`    // Percentage of each limit at which the task is warned, 0 disables
 }
 
 /**
@@ -35,6 +36,8 @@ const (
 )
 
 const (
+	TaskStatusPending   TaskStatus = "Pending"   //waiting for upstream tasks, not in queue yet
+	TaskStatusScheduled TaskStatus = "Scheduled" //waiting for start_after time, not in queue yet
 	TaskStatusQueue     TaskStatus = "Queue"     //in queue
 	TaskStatusInit      TaskStatus = "Init"      //initializing in K8S
 	TaskStatusRunning   TaskStatus = "Running"   //running
@@ -42,13 +45,17 @@ const (
 	TaskStatusFailed    TaskStatus = "Failed"    //failed
 	TaskStatusCancelled TaskStatus = "Cancelled" //cancelled by user
 	TaskStatusKilled    TaskStatus = "Killed"    //terminated by system
+	TaskStatusPreempted TaskStatus = "Preempted" //preempted by a higher priority task, queued again
+	TaskStatusSkipped   TaskStatus = "Skipped"   //skipped because an upstream task did not succeed
+	TaskStatusSuspended TaskStatus = "Suspended" //suspended by user, out of queue until resumed
 )
 
 /**
  *	Check if task status indicates completion
  */
 func (s TaskStatus) IsFinished() bool {
-	return s == TaskStatusSucceeded || s == TaskStatusFailed || s == TaskStatusCancelled || s == TaskStatusKilled
+	return s == TaskStatusSucceeded || s == TaskStatusFailed || s == TaskStatusCancelled || s == TaskStatusKilled ||
+		s == TaskStatusSkipped
 }
 
 /**
@@ -56,13 +63,13 @@ func (s TaskStatus) IsFinished() bool {
  */
 func (s TaskStatus) Phase() TaskPhase {
 	switch s {
-	case TaskStatusQueue:
+	case TaskStatusPending, TaskStatusScheduled, TaskStatusQueue, TaskStatusPreempted, TaskStatusSuspended:
 		return PhaseQueue
 	case TaskStatusInit:
 		return PhaseInit
 	case TaskStatusRunning:
 		return PhaseRunning
-	case TaskStatusSucceeded, TaskStatusFailed, TaskStatusCancelled, TaskStatusKilled:
+	case TaskStatusSucceeded, TaskStatusFailed, TaskStatusCancelled, TaskStatusKilled, TaskStatusSkipped:
 		return PhaseFinished
 	default:
 		return PhaseQueue
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"taskd/dao"
 	"taskd/internal/utils"
 	"text/template"
@@ -24,11 +25,15 @@ type EntityLogs struct {
 type TaskInstance struct {
 	dao.TaskRec
 
-	template *dao.TemplateRec  // Task template data
-	pool     *TaskPool         // Associated task pool
-	quotas   []dao.Quota       // Allocated resource quotas
-	phase    TaskPhase         // Current phase
-	tags     map[string]string // Tags
+	template *dao.TemplateRec     // Task template data
+	pool     *TaskPool            // Associated task pool
+	quotas   []dao.Quota          // Allocated resource quotas
+	gang     int                  // Replica count of a gang scheduled task, 0 if not gang scheduled
+	phase    TaskPhase            // Current phase
+	tags     map[string]string    // Tags
+	warned   map[string]time.Time // Begin of the deadlines already warned, by limit
+
+	statusMutex sync.RWMutex // Guards status and events changed by transit
 }
 
 /**
@@ -44,12 +49,15 @@ func (ti *TaskInstance) Init(td *dao.TemplateRec, tr *dao.TaskRec) error {
 	ti.TaskObjRec = tr.TaskObjRec
 	ti.template = td
 
-	if ti.YamlContent != "" {
+	if ti.YamlContent != "" { // Reloaded task continues in the phase of its status
+		ti.phase = ti.GetStatus().Phase()
 		return nil
 	}
 	now := time.Now().Local()
+	ti.addSubmitEvent(now)
 	ti.Status = string(TaskStatusQueue)
 	ti.CreateTime = &now
+	ti.QueueTime = &now
 	ti.UpdateTime = &now
 	ti.phase = PhaseQueue
 	if _, err := ti.Compile(); err != nil {
@@ -60,33 +68,71 @@ func (ti *TaskInstance) Init(td *dao.TemplateRec, tr *dao.TaskRec) error {
 
 /**
  * Prepare task instance for running
+ * Returns false if the task cannot be started from its current status
  */
-func (ti *TaskInstance) Prerun() {
-	now := time.Now().Local()
-	ti.Status = string(TaskStatusInit)
-	ti.phase = PhaseInit
-	ti.StartTime = &now
-	ti.UpdateTime = &now
+func (ti *TaskInstance) Prerun() bool {
+	if !ti.transit(TaskStatusInit, fmt.Sprintf("started in pool [%s]", ti.Pool), SourceScheduler, func() {
+		ti.phase = PhaseInit
+	}) {
+		return false
+	}
 	ti.Update()
+	return true
 }
 
 /**
- * Update status
+ * Update status reported by the runner (source) and save it
+ * Illegal transitions (e.g. a started task reported back in queue) are logged and ignored
  */
-func (ti *TaskInstance) UpdateStatus(status TaskStatus) {
-	now := time.Now().Local()
-	ti.Status = string(status)
-	ti.UpdateTime = &now
-	ti.phase = status.Phase()
+func (ti *TaskInstance) UpdateStatus(status TaskStatus, source string) {
+	if ti.transit(status, "", source, func() {
+		ti.phase = status.Phase()
+	}) {
+		ti.Update()
+	}
+}
 
-	if status == TaskStatusInit {
-		ti.StartTime = &now
-	} else if status == TaskStatusRunning {
-		ti.RunningTime = &now
-	} else if status.IsFinished() {
-		ti.EndTime = &now
+/**
+ * Put a started task back to the queue phase
+ * CreateTime is kept, so the task keeps its place among tasks of the same priority
+ * @param status TaskStatus Status while waiting in queue again
+ * @param reason string Why the task is queued again
+ * @param source string Who puts the task back
+ * Returns false if the task cannot be queued again from its current status
+ */
+func (ti *TaskInstance) Requeue(status TaskStatus, reason string, source string) bool {
+	if status.Phase() != PhaseQueue {
+		panic(fmt.Errorf("status [%s] is not in queue phase", status))
+	}
+	if !ti.transit(status, reason, source, func() {
+		now := time.Now().Local()
+		ti.Warning = reason
+		ti.Error = ""
+		ti.phase = PhaseQueue
+		ti.QueueTime = &now
+		ti.StartTime = nil
+		ti.RunningTime = nil
+		ti.EndTime = nil
+	}) {
+		return false
 	}
 	ti.Update()
+	return true
+}
+
+/**
+ * Check if the task may be preempted by higher priority tasks
+ * Set by `"preemptible": true` in template.extra
+ */
+func (ti *TaskInstance) Preemptible() bool {
+	if ti.template == nil || ti.template.Extra == "" {
+		return false
+	}
+	extra, err := ParseArgs(ti.template.Extra)
+	if err != nil {
+		return false
+	}
+	return GetArgBool(extra, "preemptible", false)
 }
 
 /**
@@ -132,25 +178,48 @@ func (ti *TaskInstance) SetError(finished TaskStatus, e error) {
 	if !finished.IsFinished() {
 		panic(fmt.Errorf("status [%s] is not completed", finished))
 	}
-	ti.Status = string(finished)
-	ti.Error = e.Error()
+	ti.transit(finished, e.Error(), SourceEngine, func() {
+		ti.Error = e.Error()
+	})
+}
+
+/**
+ * End the task by the system (source), with the error if any
+ * The phase is kept if the task failed, so a task failed in Init phase can be re-queued
+ * Returns false if the task cannot end from its current status, e.g. it has finished already
+ */
+func (ti *TaskInstance) Finish(status TaskStatus, err error, source string) bool {
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	}
+	if err != nil && !status.IsFinished() {
+		panic(fmt.Errorf("status [%s] is not completed", status))
+	}
+	return ti.transit(status, reason, source, func() {
+		if err != nil {
+			ti.Error = reason
+		} else {
+			ti.phase = status.Phase()
+		}
+	})
 }
 
 /**
  * Get timing information for current phase
  */
 func (ti *TaskInstance) GetPhaseTime() (beg time.Time, maxDuration time.Duration) {
+	// A phase entered without its time recorded (e.g. Init skipped or status set by the engine)
+	// counts from the end of the previous phase
 	switch ti.phase {
 	case PhaseQueue:
-		return *ti.CreateTime, ti.GetTimeout().Queue
+		return firstTime(ti.QueueTime, ti.CreateTime), ti.GetTimeout().Queue
 	case PhaseInit:
-		return *ti.StartTime, ti.GetTimeout().Init
+		return firstTime(ti.StartTime, ti.QueueTime, ti.CreateTime), ti.GetTimeout().Init
 	case PhaseRunning:
-		return *ti.RunningTime, ti.GetTimeout().Running
-	case PhaseFinished:
-		return *ti.EndTime, ti.GetTimeout().Whole
+		return firstTime(ti.RunningTime, ti.StartTime, ti.QueueTime, ti.CreateTime), ti.GetTimeout().Running
 	default:
-		return *ti.EndTime, ti.GetTimeout().Whole
+		return firstTime(ti.EndTime, ti.RunningTime, ti.StartTime, ti.QueueTime, ti.CreateTime), ti.GetTimeout().Whole
 	}
 }
 
@@ -179,6 +248,8 @@ func (ti *TaskInstance) Phase() TaskPhase {
  * Get instance status
  */
 func (ti *TaskInstance) GetStatus() TaskStatus {
+	ti.statusMutex.RLock()
+	defer ti.statusMutex.RUnlock()
 	return TaskStatus(ti.Status)
 }
 
@@ -186,18 +257,9 @@ func (ti *TaskInstance) GetStatus() TaskStatus {
  * Set instance status (without updating storage)
  */
 func (ti *TaskInstance) SetStatus(status TaskStatus) {
-	now := time.Now().Local()
-	ti.Status = string(status)
-	ti.UpdateTime = &now
-	ti.phase = status.Phase()
-
-	if status == TaskStatusInit {
-		ti.StartTime = &now
-	} else if status == TaskStatusRunning {
-		ti.RunningTime = &now
-	} else if status.IsFinished() {
-		ti.EndTime = &now
-	}
+	ti.transit(status, "", SourceEngine, func() {
+		ti.phase = status.Phase()
+	})
 }
 
 /**
@@ -278,16 +340,44 @@ func (ti *TaskInstance) SendCallback(message string) error {
 		return nil
 	}
 	utils.Infof("Task [%s] has finished running, send notification: %s", ti.Title(), ti.Callback)
-	type TaskFinishedCallback struct {
+	if message == "" {
+		message = fmt.Sprintf("Task [%s] has finished running", ti.Title())
+	}
+	return ti.postCallback(CallbackFinished, message)
+}
+
+/**
+ * Notify the callback address that the task is approaching a time limit
+ */
+func (ti *TaskInstance) SendWarningCallback(message string) error {
+	if ti.Callback == "" {
+		return nil
+	}
+	utils.Infof("Task [%s] is approaching a time limit, send notification: %s", ti.Title(), ti.Callback)
+	return ti.postCallback(CallbackTimeoutWarning, message)
+}
+
+/**
+ * Events sent to the callback address
+ */
+const (
+	CallbackFinished       = "finished"        // Task has finished
+	CallbackTimeoutWarning = "timeout_warning" // Task is approaching a time limit and will be stopped
+)
+
+/**
+ * Post an event of the task to the callback address
+ */
+func (ti *TaskInstance) postCallback(event string, message string) error {
+	type TaskCallback struct {
 		Name    string `json:"name"`
 		Uuid    string `json:"uuid"`
 		Status  string `json:"status"`
+		Event   string `json:"event"`
 		Message string `json:"message"`
 	}
-	var msg TaskFinishedCallback
-	if message == "" {
-		message = fmt.Sprintf("Task [%s] has finished running", ti.Title())
-	}
+	var msg TaskCallback
+	msg.Event = event
 	msg.Name = ti.Name
 	msg.Message = message
 	msg.Uuid = ti.UUID
@@ -327,6 +417,9 @@ func (ti *TaskInstance) GetTimeout() Timeout {
 	if interval.Whole != 0 {
 		timeout.Whole = time.Duration(interval.Whole) * time.Minute
 	}
+	if interval.Warn != 0 {
+		timeout.Warn = interval.Warn
+	}
 	return timeout
 }
 
@@ -363,6 +456,8 @@ func (ti *TaskInstance) GetExtra() (map[string]any, error) {
 
 /**
  * Get resource quotas defined for submitted task
+ * Quotas of a gang scheduled task are defined per replica,
+ * the result is the quota of the whole gang (replicas × per-replica quota)
  */
 func (ti *TaskInstance) GetQuotas() []dao.Quota {
 	if ti.Quotas == "" {
@@ -373,9 +468,29 @@ func (ti *TaskInstance) GetQuotas() []dao.Quota {
 		utils.Errorf("Task [%s] unmarshal 'quotas' failed: %v", ti.Title(), err)
 		return []dao.Quota{}
 	}
+	if ti.gang > 1 {
+		for i := range quotas {
+			quotas[i].ResNum *= int64(ti.gang)
+		}
+	}
 	return quotas
 }
 
+/**
+ * Enable gang scheduling: all replicas are admitted together or not at all
+ * @param replicas int Number of replicas started by the task
+ */
+func (ti *TaskInstance) SetGang(replicas int) {
+	ti.gang = replicas
+}
+
+/**
+ * Replica count of a gang scheduled task, 0 if not gang scheduled
+ */
+func (ti *TaskInstance) GetGang() int {
+	return ti.gang
+}
+
 /**
  * Allocate resource quotas for task instance
  */
@@ -475,6 +590,40 @@ func GetArgInt(args map[string]any, key string, defVal int) int {
 	return valInt
 }
 
+/**
+ * Get number parameter value by name, numbers decoded from JSON (float64) are accepted too
+ * Only for arguments of gang scheduling and re-queue, existing arguments keep reading by GetArgInt
+ */
+func GetArgNumber(args map[string]any, key string, defVal int) int {
+	val, ok := args[key]
+	if !ok {
+		return defVal
+	}
+	switch v := val.(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	default:
+		return defVal
+	}
+}
+
+/**
+ * Get boolean parameter value by name
+ */
+func GetArgBool(args map[string]any, key string, defVal bool) bool {
+	val, ok := args[key]
+	if !ok {
+		return defVal
+	}
+	valBool, ok := val.(bool)
+	if !ok {
+		return defVal
+	}
+	return valBool
+}
+
 /**
  * Get string parameter value by name
  */
@@ -1,10 +1,14 @@
 package task
 
 import (
+	"fmt"
 	"os"
+	"reflect"
 	"strings"
 	"taskd/dao"
+	"taskd/internal/utils"
 	"testing"
+	"time"
 )
 
 func TestTaskInstance_Compile(t *testing.T) {
@@ -61,3 +65,116 @@ func TestTaskInstance_Compile(t *testing.T) {
 		})
 	}
 }
+
+func TestTaskInstance_GangAdmission(t *testing.T) {
+	// Replicas of a distributed task come from JSON extra, where numbers are float64
+	extra, err := ParseArgs(`{"masterNum": 1, "workerNum": 3, "gang": true}`)
+	if err != nil {
+		t.Fatalf("ParseArgs() error = %v", err)
+	}
+	replicas := GetArgNumber(extra, "masterNum", 1) + GetArgNumber(extra, "workerNum", 0)
+	if replicas != 4 {
+		t.Fatalf("replicas = %d, want 4", replicas)
+	}
+	// Tasks not gang scheduled keep the replicas they always had
+	if got := GetArgInt(extra, "masterNum", 1) + GetArgInt(extra, "workerNum", 0); got != 1 {
+		t.Errorf("GetArgInt() replicas = %d, want 1", got)
+	}
+
+	tp := newTestPool()
+	tp.resources["gpu"] = ResourceAlloc{Name: "gpu", Capacity: utils.Quantity{Amend: 8}}
+	small := newFakeJob("small", 0)
+	small.Quotas = `[{"res_name":"gpu","res_num":1}]`
+	small.AttachPool(tp)
+	if err := small.AllocQuotas(); err != nil {
+		t.Fatalf("AllocQuotas() error = %v", err)
+	}
+
+	job := newFakeJob("gang", 0)
+	job.Quotas = `[{"res_name":"gpu","res_num":2}]`
+	job.SetGang(replicas)
+	job.AttachPool(tp)
+	if got := job.GetQuotas(); got[0].ResNum != 8 {
+		t.Errorf("GetQuotas() = %+v, want 8 gpu for the whole gang", got)
+	}
+	// 7 gpu left would fit 3 replicas, but the gang is admitted together or not at all
+	if err := job.AllocQuotas(); err == nil {
+		t.Errorf("AllocQuotas() should fail when the whole gang doesn't fit")
+	}
+	small.FreeQuotas()
+	if err := job.AllocQuotas(); err != nil {
+		t.Errorf("AllocQuotas() error = %v after resources are freed", err)
+	}
+}
+
+func TestTaskInstance_GetDeadlines(t *testing.T) {
+	created := time.Now().Add(-40 * time.Minute)
+	queued := time.Now().Add(-30 * time.Minute)
+	started := time.Now().Add(-20 * time.Minute)
+	ti := &TaskInstance{}
+	ti.UUID = "deadline"
+	ti.Timeout = `{"running": 60, "whole": 100, "warn": 80}`
+	ti.CreateTime = &created
+	ti.QueueTime = &queued
+	ti.StartTime = &started
+	ti.Status = string(TaskStatusRunning)
+	ti.YamlContent = "kind: Pod"
+	if err := ti.Init(nil, &ti.TaskRec); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if ti.Phase() != PhaseRunning {
+		t.Fatalf("reloaded phase = %v, want Running", ti.Phase())
+	}
+
+	// Running phase without RunningTime counts from the start of Init, the whole limit from submission
+	deadlines := ti.GetDeadlines()
+	want := []Deadline{
+		{Limit: "Running", Begin: started, Max: time.Hour},
+		{Limit: WholeLimit, Begin: created, Max: 100 * time.Minute},
+	}
+	if !reflect.DeepEqual(deadlines, want) {
+		t.Fatalf("GetDeadlines() = %+v, want %+v", deadlines, want)
+	}
+
+	now := time.Now()
+	if ti.MarkTimeoutWarned(deadlines[0], now) {
+		t.Errorf("Running limit warned at 33%%")
+	}
+	if !ti.MarkTimeoutWarned(deadlines[1], now.Add(50*time.Minute)) {
+		t.Errorf("Whole limit not warned at 90%%")
+	}
+	if ti.MarkTimeoutWarned(deadlines[1], now.Add(50*time.Minute)) {
+		t.Errorf("Whole limit warned twice")
+	}
+	if deadlines[1].Exceeded(now) || !deadlines[1].Exceeded(now.Add(2*time.Hour)) {
+		t.Errorf("Exceeded() of whole limit is wrong")
+	}
+}
+
+func TestTaskInstance_Events(t *testing.T) {
+	ti := &TaskInstance{}
+	ti.UUID = "events"
+	ti.addSubmitEvent(time.Now())
+	ti.Status = string(TaskStatusQueue)
+
+	ti.Finish(TaskStatusCancelled, nil, SourceUser)
+	ti.Finish(TaskStatusCancelled, nil, SourceUser) // unchanged status is not recorded
+	if len(ti.Events) != 2 {
+		t.Fatalf("events = %+v, want 2", ti.Events)
+	}
+	last := ti.LastEvent()
+	if last.From != string(TaskStatusQueue) || last.To != string(TaskStatusCancelled) || last.Source != SourceUser {
+		t.Errorf("LastEvent() = %+v", last)
+	}
+
+	for i := 0; i < maxTaskEvents; i++ {
+		ti.Status = string(TaskStatusRunning)
+		ti.Finish(TaskStatusKilled, fmt.Errorf("killed %d", i), SourceTimeout)
+	}
+	if len(ti.Events) != maxTaskEvents {
+		t.Fatalf("events = %d, want %d", len(ti.Events), maxTaskEvents)
+	}
+	if ti.Events[0].Reason != "killed 0" || ti.LastEvent().Reason != fmt.Sprintf("killed %d", maxTaskEvents-1) {
+		t.Errorf("oldest events are not dropped: first %+v, last %+v", ti.Events[0], ti.LastEvent())
+	}
+}
@@ -90,6 +90,42 @@ func NewPool(pool *dao.Pool) (*TaskPool, error) {
 	}
 }
 
+/**
+ *	Apply an updated pool definition to a live pool, the engine must stay the same
+ *	Extension is rebuilt if Config changed. Channel buffers keep their size, as
+ *	capacity is checked against Running/Waiting rather than the buffers, and nothing
+ *	is sent to them under the pool lock, so a full buffer only makes the sender wait
+ */
+func (tp *TaskPool) Reconfigure(pool *dao.Pool) error {
+	if pool.Engine != tp.Engine {
+		return fmt.Errorf("engine of pool [%s] cannot be changed from [%s] to [%s] in place", tp.PoolId, tp.Engine, pool.Engine)
+	}
+	policy, err := ParsePoolPolicy(pool.Policy)
+	if err != nil {
+		return err
+	}
+	extension := tp.GetExtension()
+	if engine := taskEngines[TaskEngineKind(tp.Engine)]; pool.Config != tp.GetConfig() && engine.InitExtension != nil {
+		scratch := &TaskPool{Pool: *pool}
+		if err := engine.InitExtension(scratch); err != nil {
+			return fmt.Errorf("pool [%s] rebuild extension failed: %v", tp.PoolId, err)
+		}
+		extension = scratch.Extension
+	}
+	tp.locker.Lock()
+	defer tp.locker.Unlock()
+	tp.Description = pool.Description
+	tp.Labels = pool.Labels
+	tp.parseLabels()
+	tp.Config = pool.Config
+	tp.Running = pool.Running
+	tp.Waiting = pool.Waiting
+	tp.Policy = pool.Policy
+	tp.policy = policy
+	tp.Extension = extension
+	return nil
+}
+
 /**
  *	Create a job instance using specified engine with data from TaskRec
  */
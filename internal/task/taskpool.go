@@ -2,6 +2,7 @@ package task
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"taskd/dao"
 	"taskd/internal/utils"
@@ -17,42 +18,72 @@ type ResourceAlloc struct {
 	Allocate utils.Quantity
 }
 
+/**
+ *	Pool state
+ */
+const (
+	PoolStateActive   = "active"   // Accept submissions and start waiting tasks
+	PoolStatePaused   = "paused"   // Accept submissions, but start no new task
+	PoolStateDraining = "draining" // Reject submissions, start waiting tasks until the pool is empty
+)
+
 /**
  * Task pool summary
  */
 type TaskPoolSummary struct {
-	PoolId     string `json:"pool_id"`     // Pool identifier
-	Engine     string `json:"engine"`      // Task engine used by pool
-	Config     string `json:"config"`      // Pool configuration
-	MaxWaiting int    `json:"max_waiting"` // Maximum queued tasks
-	MaxRunning int    `json:"max_running"` // Maximum concurrent tasks
-	Waiting    int    `json:"waiting"`     // Number of tasks currently waiting in pool
-	Running    int    `json:"running"`     // Number of tasks currently running in pool
+	PoolId       string `json:"pool_id"`      // Pool identifier
+	Engine       string `json:"engine"`       // Task engine used by pool
+	Labels       string `json:"labels"`       // Pool labels
+	State        string `json:"state"`        // Pool state: active, paused or draining
+	Backpressure string `json:"backpressure"` // Handling of submissions when the waiting queue is full
+	Config       string `json:"config"`       // Pool configuration
+	MaxWaiting   int    `json:"max_waiting"`  // Maximum queued tasks
+	MaxRunning   int    `json:"max_running"`  // Maximum concurrent tasks
+	Waiting      int    `json:"waiting"`      // Number of tasks currently waiting in pool
+	Running      int    `json:"running"`      // Number of tasks currently running in pool
+	Backlog      int    `json:"backlog"`      // Number of tasks parked in backlog
+}
+
+/**
+ *	Resource held by a running task
+ */
+type ResourceHolder struct {
+	UUID     string `json:"uuid"`     //task unique ID
+	Name     string `json:"name"`     //task name
+	Allocate string `json:"allocate"` //amount held by the task
 }
 
 /**
  *	Resource information entry
  */
 type ResourceItem struct {
-	Name     string `json:"name"`     //resource name
-	Capacity string `json:"capacity"` //configured capacity
-	Allocate string `json:"allocate"` //allocated amount
-	Remain   string `json:"remain"`   //actual remaining
+	Name     string           `json:"name"`              //resource name
+	Capacity string           `json:"capacity"`          //configured capacity
+	Allocate string           `json:"allocate"`          //allocated amount
+	Remain   string           `json:"remain"`            //actual remaining
+	Holders  []ResourceHolder `json:"holders,omitempty"` //running tasks holding the resource
 }
 
 /**
  *	Task pool details
  */
 type TaskPoolDetail struct {
-	PoolId     string                `json:"pool_id"`             // Pool ID
-	Engine     string                `json:"engine"`              //task pool engine
-	Config     string                `json:"config"`              //task pool configuration
-	MaxWaiting int                   `json:"max_waiting"`         //maximum waiting tasks
-	MaxRunning int                   `json:"max_running"`         //maximum parallel tasks
-	Waiting    int                   `json:"waiting"`             //current waiting tasks
-	Running    int                   `json:"running"`             //current running tasks
-	Tasks      []TaskInstanceSummary `json:"tasks,omitempty"`     //task information in queue or running
-	Resources  []ResourceItem        `json:"resources,omitempty"` //resource information of the pool
+	PoolId       string                `json:"pool_id"`             // Pool ID
+	Engine       string                `json:"engine"`              //task pool engine
+	Labels       string                `json:"labels"`              //pool labels
+	State        string                `json:"state"`               //pool state: active, paused or draining
+	Backpressure string                `json:"backpressure"`        //handling of submissions when the waiting queue is full
+	Config       string                `json:"config"`              //task pool configuration
+	MaxWaiting   int                   `json:"max_waiting"`         //maximum waiting tasks
+	MaxRunning   int                   `json:"max_running"`         //maximum parallel tasks
+	Waiting      int                   `json:"waiting"`             //current waiting tasks
+	Running      int                   `json:"running"`             //current running tasks
+	Backlog      int                   `json:"backlog"`             //tasks parked in backlog
+	Policy       string                `json:"policy,omitempty"`    //scheduling policy of the pool
+	Tasks        []TaskInstanceSummary `json:"tasks,omitempty"`     //task information in queue or running
+	Resources    []ResourceItem        `json:"resources,omitempty"` //resource information of the pool
+	Tenants      []TenantUsage         `json:"tenants,omitempty"`   //usage of each tenant under fair-share policy
+	Limits       []LimitUsage          `json:"limits,omitempty"`    //usage of each concurrency limit
 }
 
 /**
@@ -67,8 +98,15 @@ type TaskPool struct {
 	FinishedChan chan TaskJob             // Channel for finished tasks
 	resources    map[string]ResourceAlloc // Resource allocation table
 	runnings     map[string]TaskJob       // Running table
-	waitings     []TaskJob                // Waiting queue
+	waitings     waitingQueue             // Waiting queue ordered by priority
+	backlog      []TaskJob                // Jobs parked while the waiting queue is full, in submission order
+	labels       map[string]string        // Parsed pool labels
+	policy       PoolPolicy               // Scheduling policy
+	tenants      map[string]*tenantUsage  // Usage of each tenant (fair-share)
+	quit         chan struct{}            // Closed when the pool is retired
+	quitOnce     sync.Once                // Guards closing of quit
 	locker       sync.RWMutex             // Read-write lock
+	resLocker    sync.Mutex               // Lock of resource allocation table
 }
 
 /**
@@ -78,23 +116,59 @@ func (tp *TaskPool) Init(pool *dao.Pool) {
 	tp.Pool = *pool
 	tp.runnings = make(map[string]TaskJob)
 	tp.resources = make(map[string]ResourceAlloc)
+	tp.tenants = make(map[string]*tenantUsage)
+	tp.parseLabels()
+	if policy, err := ParsePoolPolicy(tp.Policy); err != nil {
+		utils.Errorf("Pool [%s] policy is ignored: %v", tp.PoolId, err)
+	} else {
+		tp.policy = policy
+	}
 	tp.WaitingChan = make(chan TaskJob, tp.Waiting)
-	tp.RunningChan = make(chan int)
+	tp.RunningChan = make(chan int, 1)
 	tp.FinishedChan = make(chan TaskJob, tp.Running)
+	tp.quit = make(chan struct{})
+}
+
+/**
+ *	Channel closed when the pool is retired, goroutines serving the pool exit on it
+ */
+func (tp *TaskPool) Done() <-chan struct{} {
+	return tp.quit
+}
+
+/**
+ *	Retire the pool, stop goroutines serving it
+ */
+func (tp *TaskPool) Close() {
+	tp.quitOnce.Do(func() {
+		if tp.quit != nil {
+			close(tp.quit)
+		}
+	})
 }
 
 /**
  *	Send to waiting tasks channel
+ *	Never blocks the caller, if the channel buffer is full the job is parked in the backlog
+ *	and queued once the waiting queue has room
  */
 func (tp *TaskPool) SendWaitingChan(job TaskJob) {
-	tp.WaitingChan <- job
+	select {
+	case tp.WaitingChan <- job:
+	default:
+		tp.PushBacklogJob(job)
+	}
 }
 
 /**
  *	Send to running tasks channel
+ *	Never blocks: a pending notification already makes the runner look at the queue again
  */
 func (tp *TaskPool) SendRunningChan(count int) {
-	tp.RunningChan <- count
+	select {
+	case tp.RunningChan <- count:
+	default:
+	}
 }
 
 /**
@@ -112,20 +186,28 @@ func (tp *TaskPool) GetRunningCount() int {
 }
 
 /**
- * Get count of currently waiting tasks
+ * Get count of currently waiting tasks, including tasks parked in backlog
  */
 func (tp *TaskPool) GetWaitingCount() int {
-	return len(tp.waitings)
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	return tp.waitings.Len() + len(tp.backlog)
 }
 
 /**
- *	Iterate through waiting tasks
+ *	Iterate through waiting tasks, including tasks parked in backlog
+ *	Jobs are handled out of the lock, handlers may send them to the pool's channels or remove them
  */
 func (tp *TaskPool) ForeachWaiting(handleJob func(job TaskJob) error) error {
 	tp.locker.RLock()
-	defer tp.locker.RUnlock()
+	jobs := make([]TaskJob, 0, len(tp.waitings.items)+len(tp.backlog))
+	for _, item := range tp.waitings.items {
+		jobs = append(jobs, item.job)
+	}
+	jobs = append(jobs, tp.backlog...)
+	tp.locker.RUnlock()
 
-	for _, job := range tp.waitings {
+	for _, job := range jobs {
 		if err := handleJob(job); err != nil {
 			return err
 		}
@@ -135,12 +217,17 @@ func (tp *TaskPool) ForeachWaiting(handleJob func(job TaskJob) error) error {
 
 /**
  *	Iterate through running tasks
+ *	Jobs are handled out of the lock, handlers may send them to the pool's channels or remove them
  */
 func (tp *TaskPool) ForeachRunning(handleJob func(job TaskJob) error) error {
 	tp.locker.RLock()
-	defer tp.locker.RUnlock()
-
+	jobs := make([]TaskJob, 0, len(tp.runnings))
 	for _, job := range tp.runnings {
+		jobs = append(jobs, job)
+	}
+	tp.locker.RUnlock()
+
+	for _, job := range jobs {
 		if err := handleJob(job); err != nil {
 			return err
 		}
@@ -148,11 +235,31 @@ func (tp *TaskPool) ForeachRunning(handleJob func(job TaskJob) error) error {
 	return nil
 }
 
+/**
+ *	Config of the pool, it may be replaced by Reconfigure
+ */
+func (tp *TaskPool) GetConfig() string {
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	return tp.Config
+}
+
+/**
+ *	Engine extension of the pool (e.g. k8s clientset), it is rebuilt by Reconfigure when Config changes
+ */
+func (tp *TaskPool) GetExtension() any {
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	return tp.Extension
+}
+
 /**
  *	Get capacity information
  */
 func (tp *TaskPool) GetCapacity() (waiting, running int) {
-	return tp.Waiting - len(tp.waitings), tp.Running - len(tp.runnings)
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	return tp.Waiting - tp.waitings.Len(), tp.Running - len(tp.runnings)
 }
 
 /**
@@ -166,6 +273,8 @@ func (tp *TaskPool) LoadResources() error {
 	if len(rcs) == 0 {
 		return nil
 	}
+	tp.resLocker.Lock()
+	defer tp.resLocker.Unlock()
 	for _, rc := range rcs {
 		var rhs utils.Quantity
 		if err := rhs.Parse(rc.ResNum); err != nil {
@@ -186,6 +295,10 @@ func (tp *TaskPool) ReloadResources() error {
 	if err := tp.LoadResources(); err != nil {
 		return err
 	}
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	tp.resLocker.Lock()
+	defer tp.resLocker.Unlock()
 	// Recalculate allocated resources
 	for _, job := range tp.runnings {
 		quotas := job.Instance().GetQuotas()
@@ -205,12 +318,20 @@ func (tp *TaskPool) ReloadResources() error {
 func (tp *TaskPool) GetSummary() TaskPoolSummary {
 	var result TaskPoolSummary
 	result.PoolId = tp.PoolId
-	result.Config = tp.Config
 	result.Engine = tp.Engine
+
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+
+	result.Config = tp.Config
+	result.State = tp.getState()
+	result.Labels = formatLabels(tp.labels)
+	result.Backpressure = tp.getBackpressure().Mode
 	result.MaxRunning = tp.Running
 	result.MaxWaiting = tp.Waiting
 	result.Running = len(tp.runnings)
-	result.Waiting = len(tp.waitings)
+	result.Waiting = tp.waitings.Len()
+	result.Backlog = len(tp.backlog)
 	return result
 }
 
@@ -221,24 +342,88 @@ func (tp *TaskPool) GetDetail() TaskPoolDetail {
 	var result TaskPoolDetail
 	result.PoolId = tp.PoolId
 	result.Engine = tp.Engine
-	result.Config = tp.Config
-	result.MaxRunning = tp.Running
-	result.MaxWaiting = tp.Waiting
 
 	tp.locker.RLock()
 	defer tp.locker.RUnlock()
 
+	result.Config = tp.Config
+	result.MaxRunning = tp.Running
+	result.MaxWaiting = tp.Waiting
+	result.State = tp.getState()
+	result.Labels = formatLabels(tp.labels)
+	result.Policy = tp.Policy
+	result.Backpressure = tp.getBackpressure().Mode
+
 	result.Running = len(tp.runnings)
-	result.Waiting = len(tp.waitings)
+	result.Waiting = tp.waitings.Len()
+	result.Backlog = len(tp.backlog)
 	for _, job := range tp.runnings {
 		result.Tasks = append(result.Tasks, job.Instance().GetSummary())
 	}
-	for _, job := range tp.waitings {
-		result.Tasks = append(result.Tasks, job.Instance().GetSummary())
+	// Backlog follows the waiting queue
+	for i, job := range append(tp.dequeueOrder(), tp.backlog...) {
+		summary := job.Instance().GetSummary()
+		summary.Position = i + 1
+		result.Tasks = append(result.Tasks, summary)
 	}
+	result.Resources = tp.getResourceItems()
+	result.Tenants = tp.getTenantUsages()
+	result.Limits = tp.getLimitUsages()
 	return result
 }
 
+/**
+ *	Get usage of each pool resource and the running tasks holding it
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) getResourceItems() []ResourceItem {
+	tp.resLocker.Lock()
+	defer tp.resLocker.Unlock()
+
+	var items []ResourceItem
+	for _, rc := range tp.resources {
+		remain, err := utils.QuantityMinus(rc.Capacity, rc.Allocate)
+		if err != nil {
+			utils.Errorf("Pool [%s] resource [%s] calculate remain failed: %v", tp.PoolId, rc.Name, err)
+		}
+		item := ResourceItem{
+			Name:     rc.Name,
+			Capacity: formatQuantity(rc.Capacity),
+			Allocate: formatQuantity(rc.Allocate),
+			Remain:   formatQuantity(remain),
+		}
+		for _, job := range tp.runnings {
+			ti := job.Instance()
+			for _, q := range ti.quotas {
+				if q.ResName != rc.Name {
+					continue
+				}
+				item.Holders = append(item.Holders, ResourceHolder{
+					UUID:     ti.UUID,
+					Name:     ti.Name,
+					Allocate: formatQuantity(utils.Quantity{Amend: q.ResNum, Unit: q.ResFmt}),
+				})
+			}
+		}
+		sort.Slice(item.Holders, func(i, j int) bool {
+			return item.Holders[i].UUID < item.Holders[j].UUID
+		})
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+	return items
+}
+
+/**
+ *	Format resource quantity for display
+ */
+func formatQuantity(q utils.Quantity) string {
+	q.Optimize()
+	return q.String()
+}
+
 /**
  *	Remove specified task from pool
  *	Remove matched task instance from running or waiting queue
@@ -255,27 +440,51 @@ func (tp *TaskPool) RemoveJob(job TaskJob) error {
 	// Remove from running queue
 	if _, exists := tp.runnings[ti.UUID]; exists {
 		delete(tp.runnings, ti.UUID)
+		tp.refundTenant(ti)
 		return nil
 	}
 
 	// Remove from waiting queue
-	for i, j := range tp.waitings {
-		if j.Instance().UUID == ti.UUID {
-			// Remove element using slice operation
-			tp.waitings = append(tp.waitings[:i], tp.waitings[i+1:]...)
-			return nil
-		}
+	if tp.waitings.remove(ti.UUID) {
+		return nil
+	}
+
+	// Remove from backlog
+	if tp.removeBacklogJob(ti.UUID) {
+		return nil
 	}
 
 	return fmt.Errorf("任务[%s]不在当前任务池中", ti.UUID)
 }
 
+/**
+ *	Take a queued task out of waiting queue or backlog, never out of running queue
+ *	The status is checked under the pool lock, so the task cannot be dequeued to start meanwhile
+ *	@param job TaskJob task object to take
+ */
+func (tp *TaskPool) TakeWaitingJob(job TaskJob) error {
+	tp.locker.Lock()
+	defer tp.locker.Unlock()
+
+	ti := job.Instance()
+	switch status := ti.GetStatus(); status {
+	case TaskStatusQueue, TaskStatusPreempted:
+	default:
+		return fmt.Errorf("任务[%s]状态为%s，不在等待队列中", ti.UUID, status)
+	}
+	if tp.waitings.remove(ti.UUID) || tp.removeBacklogJob(ti.UUID) {
+		return nil
+	}
+	return fmt.Errorf("任务[%s]不在等待队列中", ti.UUID)
+}
+
 /**
  *	Add task instance to task pool for execution
  */
 func (tp *TaskPool) AddRunningJob(job TaskJob) {
 	tp.locker.Lock()
 	tp.runnings[job.Instance().UUID] = job
+	tp.chargeTenant(job.Instance())
 	tp.locker.Unlock()
 }
 
@@ -284,7 +493,10 @@ func (tp *TaskPool) AddRunningJob(job TaskJob) {
  */
 func (tp *TaskPool) RemoveRunningJob(job TaskJob) {
 	tp.locker.Lock()
-	delete(tp.runnings, job.Instance().UUID)
+	if _, exists := tp.runnings[job.Instance().UUID]; exists {
+		delete(tp.runnings, job.Instance().UUID)
+		tp.refundTenant(job.Instance())
+	}
 	tp.locker.Unlock()
 }
 
@@ -293,48 +505,199 @@ func (tp *TaskPool) RemoveRunningJob(job TaskJob) {
  */
 func (tp *TaskPool) PushWaitingJob(job TaskJob) {
 	tp.locker.Lock()
-	tp.waitings = append(tp.waitings, job)
+	tp.waitings.push(job)
 	tp.locker.Unlock()
 }
 
+/**
+ * Add task to the head of waiting queue
+ */
+func (tp *TaskPool) PushFrontWaitingJob(job TaskJob) {
+	tp.locker.Lock()
+	tp.waitings.pushFront(job)
+	tp.locker.Unlock()
+}
+
+/**
+ *	Waiting tasks in dequeue order: tasks pushed to the front first,
+ *	then the others by priority, fair-share and FIFO
+ *	Caller must hold locker
+ */
+func (tp *TaskPool) dequeueOrder() []TaskJob {
+	jobs := tp.waitings.sorted()
+	tp.fairShareOrder(jobs[tp.waitings.fronts():])
+	return jobs
+}
+
 /**
  *	Pop highest priority task matching filter
- *	Dequeue task to start running
+ *	Dequeue task to start running, a nil filter matches any task.
+ *	The filter is called in dequeue order (front, priority, then fair-share, then FIFO)
+ *	until one task is accepted. Tasks reaching a concurrency limit are skipped
+ *	with a warning naming the limit, so they don't block the tasks behind them.
  */
-func (tp *TaskPool) PopWaitingJob() (TaskJob, error) {
+func (tp *TaskPool) PopWaitingJob(filter func(job TaskJob) bool) (TaskJob, error) {
 	tp.locker.Lock()
 	defer tp.locker.Unlock()
 
-	if len(tp.waitings) == 0 {
+	if filter == nil && tp.policy.FairShare == nil && len(tp.policy.Limits) == 0 {
+		if job := tp.waitings.pop(); job != nil {
+			return job, nil
+		}
 		return nil, fmt.Errorf("not exist")
 	}
-	job := tp.waitings[0]
-	tp.waitings = tp.waitings[1:]
-	return job, nil
+	for _, job := range tp.dequeueOrder() {
+		if l := tp.reachedLimit(job.Instance()); l != nil {
+			job.Instance().SetWarning(fmt.Sprintf("waiting for concurrency limit [%s]", l.String()))
+			continue
+		}
+		if filter == nil || filter(job) {
+			tp.waitings.remove(job.Instance().UUID)
+			return job, nil
+		}
+	}
+	return nil, fmt.Errorf("not exist")
 }
 
 /**
- *	Allocate resource quota for specified task
+ *	Replace scheduling policy of the pool
  */
-func (tp *TaskPool) AllocQuotas(quotas []dao.Quota) error {
-	for n, q := range quotas {
-		if rq, ok := tp.resources[q.ResName]; !ok {
-			return fmt.Errorf("there is no resource [%s] in Pool [%s]", q.ResName, tp.PoolId)
-		} else {
-			qt := utils.Quantity{
-				Amend: q.ResNum,
-				Unit:  q.ResFmt,
-			}
+func (tp *TaskPool) SetPolicy(policy string) error {
+	parsed, err := ParsePoolPolicy(policy)
+	if err != nil {
+		return err
+	}
+	tp.locker.Lock()
+	defer tp.locker.Unlock()
+	tp.Policy = policy
+	tp.policy = parsed
+	return nil
+}
+
+/**
+ *	Current state of the pool
+ */
+func (tp *TaskPool) GetState() string {
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	return tp.getState()
+}
+
+/**
+ *	Caller must hold locker, pools without state are active
+ */
+func (tp *TaskPool) getState() string {
+	if tp.State == "" {
+		return PoolStateActive
+	}
+	return tp.State
+}
 
-			if err := rq.Allocate.Plus(qt); err != nil {
-				return err
+/**
+ *	Change state of the pool
+ */
+func (tp *TaskPool) SetState(state string) error {
+	switch state {
+	case PoolStateActive, PoolStatePaused, PoolStateDraining:
+	default:
+		return fmt.Errorf("pool state must be '%s', '%s' or '%s'", PoolStateActive, PoolStatePaused, PoolStateDraining)
+	}
+	tp.locker.Lock()
+	defer tp.locker.Unlock()
+	tp.State = state
+	return nil
+}
+
+/**
+ *	Check if new tasks can be submitted to the pool
+ */
+func (tp *TaskPool) IsAccepting() bool {
+	return tp.GetState() != PoolStateDraining
+}
+
+/**
+ *	Check if waiting tasks can be started, running tasks are not affected by the state
+ */
+func (tp *TaskPool) IsStartable() bool {
+	return tp.GetState() != PoolStatePaused
+}
+
+/**
+ *	Usage of each concurrency limit of the pool
+ */
+func (tp *TaskPool) GetLimitUsages() []LimitUsage {
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+	return tp.getLimitUsages()
+}
+
+/**
+ *	Select a running task to be preempted by a task with specified priority
+ *	Only preemptible tasks with lower priority are candidates, the lowest priority
+ *	one is selected, among them the most recently started one (losing least work)
+ */
+func (tp *TaskPool) SelectPreemptee(priority int) TaskJob {
+	tp.locker.RLock()
+	defer tp.locker.RUnlock()
+
+	var selected *TaskInstance
+	var result TaskJob
+	for _, job := range tp.runnings {
+		ti := job.Instance()
+		if ti.Priority >= priority || ti.GetStatus().IsFinished() || !ti.Preemptible() {
+			continue
+		}
+		if selected != nil {
+			if ti.Priority > selected.Priority {
+				continue
 			}
-			ret, _ := utils.QuantityCompare(rq.Capacity, rq.Allocate)
-			if ret < 0 {
-				tp.FreeQuotas(quotas[:n])
-				return fmt.Errorf("resource [%s] is insufficient in Pool [%s]", q.ResName, tp.PoolId)
+			if ti.Priority == selected.Priority && !startedAfter(ti, selected) {
+				continue
 			}
 		}
+		selected = ti
+		result = job
+	}
+	return result
+}
+
+/**
+ *	Check if lhs started after rhs
+ */
+func startedAfter(lhs, rhs *TaskInstance) bool {
+	if lhs.StartTime == nil || rhs.StartTime == nil {
+		return lhs.StartTime == nil
+	}
+	return lhs.StartTime.After(*rhs.StartTime)
+}
+
+/**
+ *	Allocate resource quota for specified task
+ *	Resources not managed by the pool are not limited
+ */
+func (tp *TaskPool) AllocQuotas(quotas []dao.Quota) error {
+	tp.resLocker.Lock()
+	defer tp.resLocker.Unlock()
+
+	for n, q := range quotas {
+		rq, ok := tp.resources[q.ResName]
+		if !ok {
+			continue
+		}
+		qt := utils.Quantity{
+			Amend: q.ResNum,
+			Unit:  q.ResFmt,
+		}
+		if err := rq.Allocate.Plus(qt); err != nil {
+			tp.freeQuotas(quotas[:n])
+			return err
+		}
+		ret, _ := utils.QuantityCompare(rq.Capacity, rq.Allocate)
+		if ret < 0 {
+			tp.freeQuotas(quotas[:n])
+			return fmt.Errorf("resource [%s] is insufficient in Pool [%s]", q.ResName, tp.PoolId)
+		}
+		tp.resources[q.ResName] = rq
 	}
 	return nil
 }
@@ -343,18 +706,29 @@ func (tp *TaskPool) AllocQuotas(quotas []dao.Quota) error {
  *	Release resource quota
  */
 func (tp *TaskPool) FreeQuotas(quotas []dao.Quota) error {
+	tp.resLocker.Lock()
+	defer tp.resLocker.Unlock()
+
+	return tp.freeQuotas(quotas)
+}
+
+/**
+ *	Release resource quota (caller must hold resLocker)
+ */
+func (tp *TaskPool) freeQuotas(quotas []dao.Quota) error {
 	for _, q := range quotas {
-		if rq, ok := tp.resources[q.ResName]; !ok {
-			return fmt.Errorf("there is no resource [%s] in Pool [%s]", q.ResName, tp.PoolId)
-		} else {
-			qt := utils.Quantity{
-				Amend: q.ResNum,
-				Unit:  q.ResFmt,
-			}
-			if err := rq.Allocate.Minus(qt); err != nil {
-				return err
-			}
+		rq, ok := tp.resources[q.ResName]
+		if !ok {
+			continue
+		}
+		qt := utils.Quantity{
+			Amend: q.ResNum,
+			Unit:  q.ResFmt,
+		}
+		if err := rq.Allocate.Minus(qt); err != nil {
+			return err
 		}
+		tp.resources[q.ResName] = rq
 	}
 	return nil
 }
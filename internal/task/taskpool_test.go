@@ -0,0 +1,704 @@
+package task
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"taskd/dao"
+	"taskd/internal/utils"
+	"testing"
+	"time"
+)
+
+type fakeJob struct {
+	TaskInstance
+}
+
+func (j *fakeJob) Engine() TaskEngineKind                                { return "fake" }
+func (j *fakeJob) Start() error                                          { return nil }
+func (j *fakeJob) Stop() error                                           { return nil }
+func (j *fakeJob) FetchStatus() TaskStatus                               { return j.GetStatus() }
+func (j *fakeJob) Logs(string, int64) ([]EntityLogs, error)              { return nil, nil }
+func (j *fakeJob) FollowLogs(string, bool, int64) (io.ReadCloser, error) { return nil, nil }
+func (j *fakeJob) CustomMetrics() *Metric                                { return nil }
+
+func newFakeJob(uuid string, priority int) *fakeJob {
+	job := &fakeJob{}
+	job.UUID = uuid
+	job.Priority = priority
+	job.Status = string(TaskStatusQueue)
+	return job
+}
+
+func newFakeJobAt(uuid string, created time.Time) *fakeJob {
+	job := newFakeJob(uuid, 0)
+	job.CreateTime = &created
+	return job
+}
+
+func newTenantJob(uuid, template, project string) *fakeJob {
+	job := newFakeJob(uuid, 0)
+	job.Template = template
+	job.Project = project
+	return job
+}
+
+func newPolicyPool(policy string) *TaskPool {
+	tp := &TaskPool{}
+	tp.Init(&dao.Pool{PoolId: "test", Running: 10, Waiting: 10, Policy: policy})
+	return tp
+}
+
+func newTestPool() *TaskPool {
+	tp := &TaskPool{}
+	tp.Init(&dao.Pool{PoolId: "test", Running: 2, Waiting: 10})
+	return tp
+}
+
+func TestTaskPool_PopWaitingJob(t *testing.T) {
+	tp := newTestPool()
+	tp.PushWaitingJob(newFakeJob("low1", 0))
+	tp.PushWaitingJob(newFakeJob("high1", 10))
+	tp.PushWaitingJob(newFakeJob("low2", 0))
+	tp.PushWaitingJob(newFakeJob("mid1", 5))
+	tp.PushWaitingJob(newFakeJob("high2", 10))
+	tp.PushWaitingJob(newFakeJob("low3", 0))
+
+	want := []string{"high1", "high2", "mid1", "low1", "low2", "low3"}
+	for _, uuid := range want {
+		job, err := tp.PopWaitingJob(nil)
+		if err != nil {
+			t.Fatalf("PopWaitingJob() error = %v", err)
+		}
+		if got := job.Instance().UUID; got != uuid {
+			t.Errorf("PopWaitingJob() = %s, want %s", got, uuid)
+		}
+	}
+	if _, err := tp.PopWaitingJob(nil); err == nil {
+		t.Errorf("PopWaitingJob() on empty queue should fail")
+	}
+}
+
+func TestTaskPool_RemoveWaitingJob(t *testing.T) {
+	tp := newTestPool()
+	a := newFakeJob("a", 1)
+	b := newFakeJob("b", 3)
+	c := newFakeJob("c", 2)
+	tp.PushWaitingJob(a)
+	tp.PushWaitingJob(b)
+	tp.PushWaitingJob(c)
+
+	if err := tp.RemoveJob(c); err != nil {
+		t.Fatalf("RemoveJob() error = %v", err)
+	}
+	if err := tp.RemoveJob(c); err == nil {
+		t.Errorf("RemoveJob() twice should fail")
+	}
+	if n := tp.GetWaitingCount(); n != 2 {
+		t.Errorf("GetWaitingCount() = %d, want 2", n)
+	}
+	job, _ := tp.PopWaitingJob(nil)
+	if job != b {
+		t.Errorf("PopWaitingJob() = %s, want b", job.Instance().UUID)
+	}
+}
+
+func TestTaskPool_TakeWaitingJob(t *testing.T) {
+	tp := &TaskPool{}
+	tp.Init(&dao.Pool{PoolId: "test", Running: 2, Waiting: 1, Policy: `{"backpressure":{"mode":"backlog"}}`})
+	waiting, backlog, running := newFakeJob("waiting", 0), newFakeJob("backlog", 0), newFakeJob("running", 0)
+	tp.PushWaitingJob(waiting)
+	tp.PushBacklogJob(backlog)
+	tp.AddRunningJob(running)
+
+	// Dequeued to start but not initialized yet
+	if err := tp.TakeWaitingJob(running); err == nil {
+		t.Errorf("TakeWaitingJob() of running job should fail")
+	}
+	if n := tp.GetRunningCount(); n != 1 {
+		t.Errorf("GetRunningCount() = %d, want 1", n)
+	}
+	waiting.Status = string(TaskStatusRunning)
+	if err := tp.TakeWaitingJob(waiting); err == nil {
+		t.Errorf("TakeWaitingJob() of started job should fail")
+	}
+	waiting.Status = string(TaskStatusQueue)
+	for _, job := range []*fakeJob{waiting, backlog} {
+		if err := tp.TakeWaitingJob(job); err != nil {
+			t.Errorf("TakeWaitingJob(%s) error = %v", job.UUID, err)
+		}
+	}
+	if err := tp.TakeWaitingJob(waiting); err == nil {
+		t.Errorf("TakeWaitingJob() twice should fail")
+	}
+	if n := tp.GetWaitingCount(); n != 0 {
+		t.Errorf("GetWaitingCount() = %d, want 0", n)
+	}
+}
+
+func TestTaskPool_GetDetailPosition(t *testing.T) {
+	tp := newTestPool()
+	tp.PushWaitingJob(newFakeJob("a", 0))
+	tp.PushWaitingJob(newFakeJob("b", 7))
+	tp.PushWaitingJob(newFakeJob("c", 0))
+	tp.PushWaitingJob(newFakeJob("d", 7))
+
+	want := map[string]int{"b": 1, "d": 2, "a": 3, "c": 4}
+	detail := tp.GetDetail()
+	if len(detail.Tasks) != len(want) {
+		t.Fatalf("GetDetail().Tasks has %d items, want %d", len(detail.Tasks), len(want))
+	}
+	for _, s := range detail.Tasks {
+		if s.Position != want[s.UUID] {
+			t.Errorf("task [%s] position = %d, want %d", s.UUID, s.Position, want[s.UUID])
+		}
+	}
+}
+
+func TestTaskPool_AdmitByQuotas(t *testing.T) {
+	tp := newTestPool()
+	tp.resources["gpu"] = ResourceAlloc{Name: "gpu", Capacity: utils.Quantity{Amend: 8}}
+
+	big := newFakeJob("big", 5)
+	big.Quotas = `[{"res_name":"gpu","res_num":6}]`
+	small := newFakeJob("small", 0)
+	small.Quotas = `[{"res_name":"gpu","res_num":2}]`
+	running := newFakeJob("running", 0)
+	running.Quotas = `[{"res_name":"gpu","res_num":4}]`
+	for _, job := range []*fakeJob{big, small, running} {
+		job.AttachPool(tp)
+	}
+	if err := running.AllocQuotas(); err != nil {
+		t.Fatalf("AllocQuotas() error = %v", err)
+	}
+	tp.PushWaitingJob(big)
+	tp.PushWaitingJob(small)
+
+	admit := func(job TaskJob) bool {
+		return job.Instance().AllocQuotas() == nil
+	}
+	job, err := tp.PopWaitingJob(admit)
+	if err != nil {
+		t.Fatalf("PopWaitingJob() error = %v", err)
+	}
+	if job != small {
+		t.Errorf("PopWaitingJob() = %s, want small", job.Instance().UUID)
+	}
+	if _, err := tp.PopWaitingJob(admit); err == nil {
+		t.Errorf("PopWaitingJob() should not admit big while resource is insufficient")
+	}
+
+	running.FreeQuotas()
+	job, _ = tp.PopWaitingJob(admit)
+	if job != big {
+		t.Errorf("PopWaitingJob() should admit big after resources are freed")
+	}
+	if alloc := tp.resources["gpu"].Allocate; alloc.Amend != 8 {
+		t.Errorf("allocated gpu = %d, want 8", alloc.Amend)
+	}
+}
+
+func TestTaskPool_GetDetailResources(t *testing.T) {
+	tp := newTestPool()
+	tp.resources["memory"] = ResourceAlloc{Name: "memory", Capacity: utils.Quantity{Amend: 16, Unit: "G"}}
+	tp.resources["gpu"] = ResourceAlloc{Name: "gpu", Capacity: utils.Quantity{Amend: 8}}
+
+	job := newFakeJob("a", 0)
+	job.Name = "train"
+	job.Quotas = `[{"res_name":"gpu","res_num":2},{"res_name":"memory","res_num":1024,"res_fmt":"M"}]`
+	job.AttachPool(tp)
+	if err := job.AllocQuotas(); err != nil {
+		t.Fatalf("AllocQuotas() error = %v", err)
+	}
+	tp.AddRunningJob(job)
+
+	want := []ResourceItem{
+		{Name: "gpu", Capacity: "8", Allocate: "2", Remain: "6",
+			Holders: []ResourceHolder{{UUID: "a", Name: "train", Allocate: "2"}}},
+		{Name: "memory", Capacity: "16G", Allocate: "1G", Remain: "15G",
+			Holders: []ResourceHolder{{UUID: "a", Name: "train", Allocate: "1G"}}},
+	}
+	got := tp.GetDetail().Resources
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetDetail().Resources = %+v, want %+v", got, want)
+	}
+}
+
+func TestTaskPool_AdmitGang(t *testing.T) {
+	tp := newTestPool()
+	tp.resources["gpu"] = ResourceAlloc{Name: "gpu", Capacity: utils.Quantity{Amend: 8}}
+
+	first := newFakeJob("first", 0)
+	second := newFakeJob("second", 0)
+	for _, job := range []*fakeJob{first, second} {
+		job.Quotas = `[{"res_name":"gpu","res_num":2}]`
+		job.SetGang(3)
+		job.AttachPool(tp)
+	}
+	if err := first.AllocQuotas(); err != nil {
+		t.Fatalf("AllocQuotas() error = %v", err)
+	}
+	if err := second.AllocQuotas(); err == nil {
+		t.Errorf("AllocQuotas() should fail when the whole gang doesn't fit")
+	}
+	if alloc := tp.resources["gpu"].Allocate; alloc.Amend != 6 {
+		t.Errorf("allocated gpu = %d, want 6", alloc.Amend)
+	}
+	first.FreeQuotas()
+	if alloc := tp.resources["gpu"].Allocate; alloc.Amend != 0 {
+		t.Errorf("allocated gpu = %d, want 0", alloc.Amend)
+	}
+}
+
+func TestTaskPool_FairShare(t *testing.T) {
+	tp := newPolicyPool(`{"fair_share":{"by":"project","weights":{"b":2}}}`)
+	// Project a submits a burst before project b
+	for _, uuid := range []string{"a1", "a2", "a3"} {
+		tp.PushWaitingJob(newTenantJob(uuid, "", "a"))
+	}
+	for _, uuid := range []string{"b1", "b2", "b3"} {
+		tp.PushWaitingJob(newTenantJob(uuid, "", "b"))
+	}
+
+	// b weighs 2, so it gets two running tasks for each task of a
+	want := []string{"a1", "b1", "b2", "a2", "b3", "a3"}
+	for _, uuid := range want {
+		job, err := tp.PopWaitingJob(nil)
+		if err != nil {
+			t.Fatalf("PopWaitingJob() error = %v", err)
+		}
+		if got := job.Instance().UUID; got != uuid {
+			t.Fatalf("PopWaitingJob() = %s, want %s", got, uuid)
+		}
+		tp.AddRunningJob(job)
+	}
+
+	usages := tp.GetDetail().Tenants
+	if len(usages) != 2 || usages[0].Running != 3 || usages[1].Running != 3 || usages[1].Weight != 2 {
+		t.Errorf("GetDetail().Tenants = %+v", usages)
+	}
+}
+
+func TestParsePoolPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"project", `{"fair_share":{"by":"project"}}`, false},
+		{"owner", `{"fair_share":{"by":"owner","weights":{"u1":3}}}`, false},
+		{"bad by", `{"fair_share":{"by":"team"}}`, true},
+		{"bad weight", `{"fair_share":{"by":"owner","weights":{"u1":0}}}`, true},
+		{"limit", `{"limits":[{"by":"template","value":"t1","max":2}]}`, false},
+		{"bad limit by", `{"limits":[{"by":"team","max":2}]}`, true},
+		{"bad limit max", `{"limits":[{"by":"project","max":0}]}`, true},
+		{"backpressure", `{"backpressure":{"mode":"spill","spill_to":["b"]}}`, false},
+		{"bad backpressure", `{"backpressure":{"mode":"wait"}}`, true},
+		{"bad json", `{`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePoolPolicy(tt.policy); (err != nil) != tt.wantErr {
+				t.Errorf("ParsePoolPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPoolSelector_Matches(t *testing.T) {
+	labels, err := ParseLabels("gpu=a800, region=sh,tier=prod")
+	if err != nil {
+		t.Fatalf("ParseLabels() error = %v", err)
+	}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"", true},
+		{"gpu=a800", true},
+		{"gpu=a800,region=bj", false},
+		{"tier!=dev", true},
+		{"tier!=prod", false},
+		{"zone!=a", true},
+		{"gpu", true},
+		{"zone", false},
+		{"!spot", true},
+		{"!gpu", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			selector, err := ParsePoolSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("ParsePoolSelector() error = %v", err)
+			}
+			if got := selector.Matches(labels); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range []string{"=a800", "!", "!=dev"} {
+		if _, err := ParsePoolSelector(bad); err == nil {
+			t.Errorf("ParsePoolSelector(%q) should fail", bad)
+		}
+	}
+	if _, err := ParseLabels("gpu"); err == nil {
+		t.Errorf("ParseLabels() should fail without value")
+	}
+}
+
+func TestTaskInstance_PreferenceScore(t *testing.T) {
+	ti := &TaskInstance{}
+	ti.PoolPreferences = []dao.PoolPreference{{Selector: "region=sh", Weight: 10}, {Selector: "gpu=a800", Weight: 5}}
+	if got := ti.PreferenceScore(map[string]string{"region": "sh", "gpu": "a800"}); got != 15 {
+		t.Errorf("PreferenceScore() = %d, want 15", got)
+	}
+	if got := ti.PreferenceScore(map[string]string{"region": "bj", "gpu": "a800"}); got != 5 {
+		t.Errorf("PreferenceScore() = %d, want 5", got)
+	}
+	if err := CheckPoolSelector(&dao.TaskObjRec{PoolPreferences: []dao.PoolPreference{{Selector: "gpu", Weight: 0}}}); err == nil {
+		t.Errorf("CheckPoolSelector() should reject non-positive weight")
+	}
+}
+
+func TestTaskPool_FreeResourceRatio(t *testing.T) {
+	tp := newTestPool()
+	tp.resources["gpu"] = ResourceAlloc{Name: "gpu", Capacity: utils.Quantity{Amend: 8}, Allocate: utils.Quantity{Amend: 2}}
+	tp.resources["memory"] = ResourceAlloc{Name: "memory", Capacity: utils.Quantity{Amend: 16, Unit: "G"}}
+
+	if got := tp.FreeResourceRatio([]dao.Quota{{ResName: "gpu", ResNum: 2}}); got != 0.5 {
+		t.Errorf("FreeResourceRatio() = %v, want 0.5", got)
+	}
+	got := tp.FreeResourceRatio([]dao.Quota{{ResName: "gpu", ResNum: 2}, {ResName: "memory", ResNum: 12, ResFmt: "G"}})
+	if got != 0.25 {
+		t.Errorf("FreeResourceRatio() = %v, want 0.25", got)
+	}
+	if got := tp.FreeResourceRatio([]dao.Quota{{ResName: "cpu", ResNum: 2}}); got != 1 {
+		t.Errorf("FreeResourceRatio() = %v, want 1", got)
+	}
+}
+
+func TestTaskPool_ConcurrencyLimits(t *testing.T) {
+	tp := newPolicyPool(`{"limits":[{"by":"template","value":"build","max":1},{"by":"project","max":2}]}`)
+	build2 := newTenantJob("build2", "build", "b")
+	a2 := newTenantJob("a2", "train", "a")
+	tp.PushWaitingJob(newTenantJob("build1", "build", "a"))
+	tp.PushWaitingJob(build2)
+	tp.PushWaitingJob(newTenantJob("a1", "train", "a"))
+	tp.PushWaitingJob(a2)
+	tp.PushWaitingJob(newTenantJob("b1", "train", "b"))
+
+	// build2 is skipped by the template limit, a2 by the project limit
+	want := []string{"build1", "a1", "b1"}
+	for _, uuid := range want {
+		job, err := tp.PopWaitingJob(nil)
+		if err != nil {
+			t.Fatalf("PopWaitingJob() error = %v", err)
+		}
+		if got := job.Instance().UUID; got != uuid {
+			t.Fatalf("PopWaitingJob() = %s, want %s", got, uuid)
+		}
+		tp.AddRunningJob(job)
+	}
+	if job, err := tp.PopWaitingJob(nil); err == nil {
+		t.Fatalf("PopWaitingJob() = %s, want none", job.Instance().UUID)
+	}
+	if want := "waiting for concurrency limit [template=build<=1]"; build2.Warning != want {
+		t.Errorf("Warning of build2 = %s, want %s", build2.Warning, want)
+	}
+	if want := "waiting for concurrency limit [project=*<=2]"; a2.Warning != want {
+		t.Errorf("Warning of a2 = %s, want %s", a2.Warning, want)
+	}
+
+	usages := tp.GetLimitUsages()
+	want2 := []LimitUsage{
+		{ConcurrencyLimit{LimitByTemplate, "build", 1}, 1, 1},
+		{ConcurrencyLimit{LimitByProject, "a", 2}, 2, 1},
+		{ConcurrencyLimit{LimitByProject, "b", 2}, 1, 0},
+	}
+	if !reflect.DeepEqual(usages, want2) {
+		t.Errorf("GetLimitUsages() = %+v, want %+v", usages, want2)
+	}
+}
+
+func TestTaskPool_State(t *testing.T) {
+	tp := newTestPool()
+	if got := tp.GetState(); got != PoolStateActive {
+		t.Errorf("GetState() = %s, want %s", got, PoolStateActive)
+	}
+	tests := []struct {
+		state     string
+		accepting bool
+		startable bool
+	}{
+		{PoolStatePaused, true, false},
+		{PoolStateDraining, false, true},
+		{PoolStateActive, true, true},
+	}
+	for _, tt := range tests {
+		if err := tp.SetState(tt.state); err != nil {
+			t.Fatalf("SetState(%s) error = %v", tt.state, err)
+		}
+		if tp.IsAccepting() != tt.accepting || tp.IsStartable() != tt.startable {
+			t.Errorf("state %s: IsAccepting() = %v, IsStartable() = %v", tt.state, tp.IsAccepting(), tp.IsStartable())
+		}
+		if got := tp.GetSummary().State; got != tt.state {
+			t.Errorf("GetSummary().State = %s, want %s", got, tt.state)
+		}
+	}
+	if err := tp.SetState("stopped"); err == nil {
+		t.Errorf("SetState() with unknown state should fail")
+	}
+}
+
+func TestTaskPool_Reconfigure(t *testing.T) {
+	builds := 0
+	RegisterEngine("fake", nil, func(tp *TaskPool) error {
+		builds++
+		tp.Extension = tp.Config
+		return nil
+	}, nil)
+	tp := &TaskPool{}
+	tp.Init(&dao.Pool{PoolId: "test", Engine: "fake", Config: "kubeconfig-a", Running: 1, Waiting: 10})
+	tp.Extension = "kubeconfig-a"
+	tp.AddRunningJob(newFakeJob("running", 0))
+
+	pool := tp.Pool
+	pool.Running = 3
+	if err := tp.Reconfigure(&pool); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	if _, running := tp.GetCapacity(); running != 2 || builds != 0 {
+		t.Errorf("running capacity = %d, extension builds = %d, want 2, 0", running, builds)
+	}
+
+	pool.Config = "kubeconfig-b"
+	if err := tp.Reconfigure(&pool); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	if tp.Extension != "kubeconfig-b" || builds != 1 {
+		t.Errorf("Extension = %v, builds = %d, want rebuilt with new config", tp.Extension, builds)
+	}
+
+	pool.Engine = "pod"
+	if err := tp.Reconfigure(&pool); err == nil {
+		t.Errorf("Reconfigure() should not change engine in place")
+	}
+	pool.Engine = "fake"
+	pool.Policy = `{`
+	if err := tp.Reconfigure(&pool); err == nil {
+		t.Errorf("Reconfigure() should reject invalid policy")
+	}
+}
+
+func TestTaskPool_ReconfigureConcurrent(t *testing.T) {
+	RegisterEngine("fake", nil, func(tp *TaskPool) error {
+		tp.Extension = tp.Config
+		return nil
+	}, nil)
+	tp := &TaskPool{}
+	tp.Init(&dao.Pool{PoolId: "test", Engine: "fake", Config: "kubeconfig-0", Running: 1, Waiting: 10})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 100; i++ {
+			pool := dao.Pool{PoolId: "test", Engine: "fake", Config: fmt.Sprintf("kubeconfig-%d", i), Running: i, Waiting: 10}
+			if err := tp.Reconfigure(&pool); err != nil {
+				t.Errorf("Reconfigure() error = %v", err)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		tp.GetSummary()
+		tp.GetDetail()
+		tp.GetExtension()
+	}
+	<-done
+	if summary := tp.GetSummary(); summary.MaxRunning != 100 || tp.GetExtension() != "kubeconfig-100" {
+		t.Errorf("GetSummary() = %+v, extension = %v, want the last update", summary, tp.GetExtension())
+	}
+}
+
+func TestTaskPool_ReconfigureRaisesRunning(t *testing.T) {
+	tp := &TaskPool{}
+	tp.Init(&dao.Pool{PoolId: "test", Running: 1, Waiting: 10})
+	pool := tp.Pool
+	pool.Running = 3
+	if err := tp.Reconfigure(&pool); err != nil {
+		t.Fatalf("Reconfigure() error = %v", err)
+	}
+	for _, uuid := range []string{"r1", "r2", "r3"} {
+		tp.AddRunningJob(newFakeJob(uuid, 0))
+	}
+	// More finished jobs than FinishedChan holds, the runner must not block the pool lock while sending
+	go tp.ForeachRunning(func(job TaskJob) error {
+		tp.SendFinishedChan(job)
+		return nil
+	})
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			tp.RemoveJob(<-tp.FinishedChan)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("finished jobs are not delivered, the pool is deadlocked")
+	}
+	if got := tp.GetRunningCount(); got != 0 {
+		t.Errorf("GetRunningCount() = %d, want 0", got)
+	}
+}
+
+func TestTaskPool_Backlog(t *testing.T) {
+	tp := &TaskPool{}
+	tp.Init(&dao.Pool{PoolId: "test", Running: 1, Waiting: 2, Policy: `{"backpressure":{"mode":"backlog"}}`})
+	if bp := tp.GetBackpressure(); bp.Mode != BackpressureBacklog || bp.RetryAfter != defaultRetryAfter {
+		t.Errorf("GetBackpressure() = %+v", bp)
+	}
+	tp.PushWaitingJob(newFakeJob("w1", 0))
+	tp.PushWaitingJob(newFakeJob("w2", 0))
+	if !tp.IsFull() {
+		t.Fatalf("IsFull() = false, want true")
+	}
+	b1, b2 := newFakeJob("b1", 0), newFakeJob("b2", 0)
+	tp.PushBacklogJob(b1)
+	tp.PushBacklogJob(b2)
+	if got := tp.GetWaitingCount(); got != 4 {
+		t.Errorf("GetWaitingCount() = %d, want 4", got)
+	}
+	if moved := tp.FillFromBacklog(); moved != 0 {
+		t.Errorf("FillFromBacklog() on full queue = %d, want 0", moved)
+	}
+
+	tp.PopWaitingJob(nil)
+	if moved := tp.FillFromBacklog(); moved != 1 || b1.Warning != "" {
+		t.Errorf("FillFromBacklog() = %d, warning of b1 = %q, want b1 moved", moved, b1.Warning)
+	}
+	if err := tp.RemoveJob(b2); err != nil {
+		t.Errorf("RemoveJob() of backlog job error = %v", err)
+	}
+	if summary := tp.GetSummary(); summary.Backlog != 0 || summary.Waiting != 2 || summary.Backpressure != BackpressureBacklog {
+		t.Errorf("GetSummary() = %+v", summary)
+	}
+}
+
+func TestTaskPool_SendWaitingChan(t *testing.T) {
+	tp := &TaskPool{}
+	tp.Init(&dao.Pool{PoolId: "test", Running: 1, Waiting: 1})
+	w1, w2 := newFakeJob("w1", 0), newFakeJob("w2", 0)
+	tp.SendWaitingChan(w1)
+	tp.SendWaitingChan(w2)
+	if summary := tp.GetSummary(); len(tp.WaitingChan) != 1 || summary.Backlog != 1 {
+		t.Fatalf("channel = %d, backlog = %d, want the overflow parked in backlog", len(tp.WaitingChan), summary.Backlog)
+	}
+	tp.PushWaitingJob(<-tp.WaitingChan)
+	tp.PopWaitingJob(nil)
+	if moved := tp.FillFromBacklog(); moved != 1 {
+		t.Errorf("FillFromBacklog() = %d, want the parked job queued", moved)
+	}
+}
+
+func TestTaskPool_SelectPreemptee(t *testing.T) {
+	tp := newTestPool()
+	preemptible := &dao.TemplateRec{Name: "train", Extra: `{"preemptible": true}`}
+	guarded := &dao.TemplateRec{Name: "deploy"}
+
+	newRunning := func(uuid string, priority int, td *dao.TemplateRec, started time.Time) *fakeJob {
+		job := newFakeJob(uuid, priority)
+		job.template = td
+		job.Status = string(TaskStatusRunning)
+		job.StartTime = &started
+		tp.AddRunningJob(job)
+		return job
+	}
+	now := time.Now()
+	newRunning("low-old", 0, preemptible, now.Add(-2*time.Hour))
+	lowNew := newRunning("low-new", 0, preemptible, now.Add(-time.Hour))
+	newRunning("mid", 5, preemptible, now)
+	newRunning("guarded", -1, guarded, now)
+
+	if got := tp.SelectPreemptee(10); got != lowNew {
+		t.Errorf("SelectPreemptee(10) = %v, want low-new", got)
+	}
+	if got := tp.SelectPreemptee(0); got != nil {
+		t.Errorf("SelectPreemptee(0) = %s, want nil", got.Instance().UUID)
+	}
+}
+
+func TestTaskPool_RequeueKeepsPlace(t *testing.T) {
+	tp := newTestPool()
+	now := time.Now()
+	tp.PushWaitingJob(newFakeJobAt("second", now.Add(-time.Minute)))
+	tp.PushWaitingJob(newFakeJobAt("third", now))
+	// A preempted task created earlier is queued again after the others
+	tp.PushWaitingJob(newFakeJobAt("first", now.Add(-time.Hour)))
+
+	for _, uuid := range []string{"first", "second", "third"} {
+		job, _ := tp.PopWaitingJob(nil)
+		if got := job.Instance().UUID; got != uuid {
+			t.Errorf("PopWaitingJob() = %s, want %s", got, uuid)
+		}
+	}
+}
+
+func TestTaskPool_PushFrontWaitingJob(t *testing.T) {
+	tp := &TaskPool{}
+	tp.Init(&dao.Pool{
+		PoolId:  "test",
+		Running: 10,
+		Waiting: 10,
+		Policy:  `{"fair_share":{"by":"project"}}`,
+	})
+	tp.PushWaitingJob(newFakeJob("high", 10))
+	tp.PushWaitingJob(newFakeJob("low", 0))
+	// Tasks failed in Init phase go to the head regardless of priority, in the order they failed
+	tp.PushFrontWaitingJob(newFakeJob("init1", 0))
+	tp.PushFrontWaitingJob(newFakeJob("init2", 5))
+
+	for i, task := range tp.GetDetail().Tasks {
+		if want := []string{"init1", "init2", "high", "low"}[i]; task.UUID != want {
+			t.Errorf("GetDetail().Tasks[%d] = %s, want %s", i, task.UUID, want)
+		}
+	}
+	for _, uuid := range []string{"init1", "init2", "high", "low"} {
+		job, _ := tp.PopWaitingJob(nil)
+		if got := job.Instance().UUID; got != uuid {
+			t.Errorf("PopWaitingJob() = %s, want %s", got, uuid)
+		}
+	}
+}
+
+func TestTaskInstance_Requeueable(t *testing.T) {
+	ti := &TaskInstance{template: &dao.TemplateRec{Extra: `{"requeue_limit": 2}`}}
+	ti.phase = PhaseInit
+	ti.Status = string(TaskStatusFailed)
+	if !ti.Requeueable() {
+		t.Errorf("Requeueable() of task failed in Init phase should be true")
+	}
+	ti.Requeues = 2
+	if ti.Requeueable() {
+		t.Errorf("Requeueable() should be false when the limit is reached")
+	}
+	ti.Requeues = 0
+	ti.Status = string(TaskStatusCancelled)
+	if ti.Requeueable() {
+		t.Errorf("Requeueable() of cancelled task should be false")
+	}
+	ti.Status = string(TaskStatusFailed)
+	ti.phase = PhaseRunning
+	if ti.Requeueable() {
+		t.Errorf("Requeueable() of task failed in Running phase should be false")
+	}
+	created := time.Now().Add(-2 * time.Hour)
+	ti.CreateTime = &created
+	ti.Timeout = `{"whole": 60}`
+	ti.phase = PhaseInit
+	ti.Status = string(TaskStatusKilled)
+	if ti.Requeueable() {
+		t.Errorf("Requeueable() of task killed by whole limit should be false")
+	}
+}
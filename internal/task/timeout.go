@@ -1,6 +1,7 @@
 package task
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -12,6 +13,7 @@ type TimeoutSetting struct {
 	Init    int `json:"init,omitempty"`    // Init phase duration: Max time between task invocation and initialization completion
 	Running int `json:"running,omitempty"` // Running phase duration: Time from initialization complete to task end
 	Whole   int `json:"whole,omitempty"`   // Total duration: Max time from submission to overall completion
+	Warn    int `json:"warn,omitempty"`    // Percentage of each limit at which a warning is sent before the task is killed, 0 disables
 }
 
 /**
@@ -40,4 +42,120 @@ func SetDefaultTimeout(interval TimeoutSetting) {
 	if interval.Whole != 0 {
 		defaultTimeout.Whole = time.Duration(interval.Whole) * time.Minute
 	}
+	if interval.Warn != 0 {
+		defaultTimeout.Warn = interval.Warn
+	}
+}
+
+/**
+ * Name of the whole limit, phase limits are named by their phase
+ */
+const WholeLimit = "Whole"
+
+/**
+ * A time limit of a task and when it starts counting
+ */
+type Deadline struct {
+	Limit string        // Queue, Init, Running or Whole
+	Begin time.Time     // Time the limit counts from
+	Max   time.Duration // Max duration allowed
+}
+
+/**
+ * Time the limit expires
+ */
+func (d Deadline) At() time.Time {
+	return d.Begin.Add(d.Max)
+}
+
+/**
+ * Check if the limit has expired
+ */
+func (d Deadline) Exceeded(now time.Time) bool {
+	return now.Sub(d.Begin) >= d.Max
+}
+
+/**
+ * Check if the elapsed time has reached percent of the limit, percent out of (0, 100) never does
+ */
+func (d Deadline) Reached(now time.Time, percent int) bool {
+	if percent <= 0 || percent >= 100 {
+		return false
+	}
+	return now.Sub(d.Begin) >= d.Max*time.Duration(percent)/100
+}
+
+/**
+ * Error of a task stopped by the limit
+ */
+func (d Deadline) Error() error {
+	if d.Limit == WholeLimit {
+		return fmt.Errorf("task execution exceeded total time limit: %v", d.Max)
+	}
+	return fmt.Errorf("%s phase execution exceeded limit: %v", d.Limit, d.Max)
+}
+
+/**
+ * Warning of a task approaching the limit
+ */
+func (d Deadline) Warning(percent int) string {
+	if d.Limit == WholeLimit {
+		return fmt.Sprintf("task execution reached %d%% of total time limit %v, it will be stopped at %s",
+			percent, d.Max, d.At().Format(time.DateTime))
+	}
+	return fmt.Sprintf("%s phase execution reached %d%% of limit %v, it will be stopped at %s",
+		d.Limit, percent, d.Max, d.At().Format(time.DateTime))
+}
+
+/**
+ * Time limits of the task in its current phase: the phase limit and the whole limit
+ */
+func (ti *TaskInstance) GetDeadlines() []Deadline {
+	if ti.phase == PhaseFinished {
+		return nil
+	}
+	begTime, maxDuration := ti.GetPhaseTime()
+	return []Deadline{
+		{Limit: ti.phase.String(), Begin: begTime, Max: maxDuration},
+		ti.wholeDeadline(),
+	}
+}
+
+/**
+ * The whole limit counts from submission, it is not reset when the task is put back
+ * to the queue (re-queued, retried, preempted or resumed)
+ */
+func (ti *TaskInstance) wholeDeadline() Deadline {
+	return Deadline{Limit: WholeLimit, Begin: firstTime(ti.CreateTime, ti.QueueTime), Max: ti.GetTimeout().Whole}
+}
+
+/**
+ * Record that the task is warned of the deadline, returns false if the warning is not due
+ * or it has been sent for the same deadline already
+ * Warnings are kept in memory, a task may be warned again after restart
+ */
+func (ti *TaskInstance) MarkTimeoutWarned(d Deadline, now time.Time) bool {
+	if !d.Reached(now, ti.GetTimeout().Warn) || d.Exceeded(now) {
+		return false
+	}
+	if begin, ok := ti.warned[d.Limit]; ok && begin.Equal(d.Begin) {
+		return false
+	}
+	if ti.warned == nil {
+		ti.warned = make(map[string]time.Time)
+	}
+	ti.warned[d.Limit] = d.Begin
+	return true
+}
+
+/**
+ * First time set, or now if none is
+ */
+func firstTime(times ...*time.Time) time.Time {
+	for _, t := range times {
+		if t != nil {
+			return *t
+		}
+	}
+	return time.Now().Local()
 }
@@ -0,0 +1,155 @@
+package task
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+)
+
+/**
+ *	Entry of the waiting queue
+ */
+type waitingItem struct {
+	job      TaskJob   // Queued task
+	priority int       // Task priority, larger value is dequeued earlier
+	created  time.Time // Task creation time, keeps FIFO order inside a priority level
+	seq      int64     // Enqueue sequence, breaks ties of creation time
+	front    bool      // Put back to the head of the queue, dequeued before all other tasks
+}
+
+/**
+ *	Waiting queue ordered by priority (implements heap.Interface)
+ *	Tasks with higher priority are dequeued first,
+ *	tasks with the same priority are dequeued in FIFO order of their creation,
+ *	so a task put back to the queue keeps its original place.
+ *	Tasks pushed to the front are dequeued before all others, in the order they were pushed
+ */
+type waitingQueue struct {
+	items []*waitingItem
+	seq   int64
+}
+
+func (q *waitingQueue) Len() int {
+	return len(q.items)
+}
+
+func (q *waitingQueue) Less(i, j int) bool {
+	return q.items[i].before(q.items[j])
+}
+
+func (q *waitingQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+}
+
+func (q *waitingQueue) Push(x any) {
+	q.items = append(q.items, x.(*waitingItem))
+}
+
+func (q *waitingQueue) Pop() any {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	return item
+}
+
+/**
+ *	Check if item should be dequeued before rhs
+ */
+func (item *waitingItem) before(rhs *waitingItem) bool {
+	if item.front != rhs.front {
+		return item.front
+	}
+	if item.front {
+		return item.seq < rhs.seq
+	}
+	if item.priority != rhs.priority {
+		return item.priority > rhs.priority
+	}
+	if !item.created.Equal(rhs.created) {
+		return item.created.Before(rhs.created)
+	}
+	return item.seq < rhs.seq
+}
+
+/**
+ *	Add job to the queue
+ */
+func (q *waitingQueue) push(job TaskJob) {
+	q.add(job, false)
+}
+
+/**
+ *	Add job to the head of the queue
+ */
+func (q *waitingQueue) pushFront(job TaskJob) {
+	q.add(job, true)
+}
+
+func (q *waitingQueue) add(job TaskJob, front bool) {
+	ti := job.Instance()
+	created := time.Now()
+	if ti.CreateTime != nil {
+		created = *ti.CreateTime
+	}
+	q.seq++
+	heap.Push(q, &waitingItem{
+		job:      job,
+		priority: ti.Priority,
+		created:  created,
+		seq:      q.seq,
+		front:    front,
+	})
+}
+
+/**
+ *	Remove and return the job at the head of the queue
+ */
+func (q *waitingQueue) pop() TaskJob {
+	if len(q.items) == 0 {
+		return nil
+	}
+	return heap.Pop(q).(*waitingItem).job
+}
+
+/**
+ *	Remove job with specified UUID from the queue
+ */
+func (q *waitingQueue) remove(uuid string) bool {
+	for i, item := range q.items {
+		if item.job.Instance().UUID == uuid {
+			heap.Remove(q, i)
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ *	Count of jobs pushed to the front
+ */
+func (q *waitingQueue) fronts() int {
+	count := 0
+	for _, item := range q.items {
+		if item.front {
+			count++
+		}
+	}
+	return count
+}
+
+/**
+ *	Jobs in dequeue order
+ */
+func (q *waitingQueue) sorted() []TaskJob {
+	items := make([]*waitingItem, len(q.items))
+	copy(items, q.items)
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].before(items[j])
+	})
+	jobs := make([]TaskJob, 0, len(items))
+	for _, item := range items {
+		jobs = append(jobs, item.job)
+	}
+	return jobs
+}
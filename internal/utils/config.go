@@ -39,12 +39,22 @@ type ServerConfig struct {
  * @param PhaseInitDefault Default initialization phase timeout (seconds)
  * @param PhaseRunningDefault Default running phase timeout (seconds)
  * @param PhaseWholeDefault Default whole task timeout (seconds)
+ * @param WarnPercent Default percentage of each timeout at which a task is warned, 0 disables
  */
 type TimeoutConfig struct {
 	PhaseQueueDefault   int `yaml:"phaseQueueDefault"`
 	PhaseInitDefault    int `yaml:"phaseInitDefault"`
 	PhaseRunningDefault int `yaml:"phaseRunningDefault"`
 	PhaseWholeDefault   int `yaml:"phaseWholeDefault"`
+	WarnPercent         int `yaml:"warnPercent"`
+}
+
+/*
+ * Re-queue configuration
+ * @param InitLimit Default max times a task failing in Init phase is put back to the queue
+ */
+type RequeueConfig struct {
+	InitLimit int `yaml:"initLimit"`
 }
 
 /*
@@ -95,6 +105,7 @@ type LoggerConfig struct {
  * @param Db Database configuration
  * @param Redis Redis configuration
  * @param Timeout Timeout configuration
+ * @param Requeue Re-queue configuration
  * @param WeChat WeChat notification configuration
  * @param LokiURL Loki log service URL
  * @param Priority Task priority configuration
@@ -105,6 +116,7 @@ type Config struct {
 	Redis   RedisConfig   `yaml:"redis"`
 	Server  ServerConfig  `yaml:"server"`
 	Timeout TimeoutConfig `yaml:"timeout"`
+	Requeue RequeueConfig `yaml:"requeue"`
 	WeChat  WeChatConfig  `yaml:"wechat"`
 	LokiURL string        `yaml:"loki"`
 	Logger  LoggerConfig  `yaml:"logger"`
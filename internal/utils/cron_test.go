@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron_Next(t *testing.T) {
+	base := time.Date(2025, 5, 28, 10, 17, 30, 0, time.Local) // Wednesday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2025, 5, 28, 10, 18, 0, 0, time.Local)},
+		{"*/15 * * * *", time.Date(2025, 5, 28, 10, 30, 0, 0, time.Local)},
+		{"30 2 * * *", time.Date(2025, 5, 29, 2, 30, 0, 0, time.Local)},
+		{"@daily", time.Date(2025, 5, 29, 0, 0, 0, 0, time.Local)},
+		{"@hourly", time.Date(2025, 5, 28, 11, 0, 0, 0, time.Local)},
+		{"0 9 * * 1-5", time.Date(2025, 5, 29, 9, 0, 0, 0, time.Local)},
+		{"0 9 * * 7", time.Date(2025, 6, 1, 9, 0, 0, 0, time.Local)},
+		{"0 0 1 * *", time.Date(2025, 6, 1, 0, 0, 0, 0, time.Local)},
+		{"0 0 13 * 5", time.Date(2025, 5, 30, 0, 0, 0, 0, time.Local)}, // Day-of-month or day-of-week
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.Local)},
+		{"5,45 10 * * *", time.Date(2025, 5, 28, 10, 45, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCron() error = %v", err)
+			}
+			if got := c.Next(base); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) should fail", expr)
+		}
+	}
+}
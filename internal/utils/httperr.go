@@ -8,8 +8,9 @@ import "fmt"
  * @param err Original error object
  */
 type HttpError struct {
-	code int
-	err  error
+	code       int
+	err        error
+	retryAfter int
 }
 
 /*
@@ -28,6 +29,24 @@ func (e *HttpError) Error() string {
 	return e.err.Error()
 }
 
+/*
+ * Seconds the client should wait before retrying, 0 if not set
+ * @return int Value of Retry-After header
+ */
+func (e *HttpError) RetryAfter() int {
+	return e.retryAfter
+}
+
+/*
+ * Set Retry-After of the error
+ * @param seconds Seconds the client should wait before retrying
+ * @return *HttpError HTTP error object
+ */
+func (e *HttpError) WithRetryAfter(seconds int) *HttpError {
+	e.retryAfter = seconds
+	return e
+}
+
 /*
  * Get original error object
  * @return error Original error object
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -181,11 +182,18 @@ func (ss *Session) mkUrlByKvs(apiPath string, kvs map[string]string) string {
  * Send HTTP request
  */
 func (ss *Session) Request(method, apiPath string, paths, queries, headers map[string]string, body []byte) ([]byte, error) {
+	return ss.RequestContext(context.Background(), method, apiPath, paths, queries, headers, body)
+}
+
+/**
+ * Send HTTP request, which is aborted when ctx is cancelled
+ */
+func (ss *Session) RequestContext(ctx context.Context, method, apiPath string, paths, queries, headers map[string]string, body []byte) ([]byte, error) {
 	var rd io.Reader
 	if len(body) > 0 {
 		rd = bytes.NewReader(body)
 	}
-	req, err := http.NewRequest(method, ss.mkUrlByKvs(apiPath, paths), rd)
+	req, err := http.NewRequestWithContext(ctx, method, ss.mkUrlByKvs(apiPath, paths), rd)
 	if err != nil {
 		return nil, err
 	}
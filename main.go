@@ -13,6 +13,7 @@ import (
 	"taskd/internal/flow"
 	"taskd/internal/task"
 	"taskd/internal/utils"
+	"taskd/service"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -53,6 +54,7 @@ func main() {
 	utils.InitLokiLog(c.LokiURL)
 
 	initProcess(c)
+	go service.RunSchedules()
 
 	runHttpServer(&c.Server)
 }
@@ -156,10 +158,21 @@ func runHttpServer(c *utils.ServerConfig) {
 		apiv1.GET("/tasks", controllers.ListTasks)
 		apiv1.GET("/tasks/:uuid", controllers.TaskData)
 		apiv1.GET("/tasks/:uuid/status", controllers.TaskStatus)
+		apiv1.GET("/tasks/:uuid/events", controllers.TaskEvents)
 		apiv1.GET("/tasks/:uuid/logs", controllers.TaskLogs)
+		apiv1.GET("/tasks/:uuid/graph", controllers.TaskGraph)
 		apiv1.GET("/tasks/:uuid/tags", controllers.TaskGetTags)
 		apiv1.POST("/tasks/:uuid/tags", controllers.TaskTags)
 		apiv1.DELETE("/tasks/:uuid", controllers.TaskStop)
+		apiv1.POST("/tasks/:uuid/suspend", controllers.TaskSuspend)
+		apiv1.POST("/tasks/:uuid/resume", controllers.TaskResume)
+		apiv1.POST("/tasks/:uuid/move", controllers.TaskMove)
+		apiv1.POST("/tasks/:uuid/rerun", controllers.TaskRerun)
+
+		// Batches
+		apiv1.POST("/batches", controllers.BatchCommit)
+		apiv1.GET("/batches/:id", controllers.BatchStatus)
+		apiv1.DELETE("/batches/:id", controllers.BatchCancel)
 
 		// Task templates
 		apiv1.POST("/templates", controllers.AddTemplate)
@@ -168,12 +181,25 @@ func runHttpServer(c *utils.ServerConfig) {
 		apiv1.GET("/templates/:name", controllers.GetTemplate)
 		apiv1.DELETE("/templates/:name", controllers.DeleteTemplate)
 
+		// Schedules
+		apiv1.POST("/schedules", controllers.AddSchedule)
+		apiv1.GET("/schedules", controllers.ListSchedules)
+		apiv1.GET("/schedules/:name", controllers.GetSchedule)
+		apiv1.PUT("/schedules/:name", controllers.UpdateSchedule)
+		apiv1.DELETE("/schedules/:name", controllers.DeleteSchedule)
+		apiv1.GET("/schedules/:name/runs", controllers.ListScheduleRuns)
+
 		// Task pools
 		apiv1.POST("/pools", controllers.AddPool)
 		apiv1.GET("/pools", controllers.ListPools)
 		apiv1.GET("/pools/:name", controllers.GetPool)
 		apiv1.PUT("/pools/:name", controllers.UpdatePool)
 		apiv1.DELETE("/pools/:name", controllers.DeletePool)
+		apiv1.GET("/pools/:name/limits", controllers.GetPoolLimits)
+		apiv1.PUT("/pools/:name/limits", controllers.SetPoolLimits)
+		apiv1.POST("/pools/:name/pause", controllers.PausePool)
+		apiv1.POST("/pools/:name/drain", controllers.DrainPool)
+		apiv1.POST("/pools/:name/resume", controllers.ResumePool)
 	}
 	err := r.Run(c.ListenAddr)
 	if err != nil {
@@ -198,7 +224,9 @@ func initProcess(c *utils.Config) {
 		Init:    c.Timeout.PhaseInitDefault,
 		Running: c.Timeout.PhaseRunningDefault,
 		Whole:   c.Timeout.PhaseWholeDefault,
+		Warn:    c.Timeout.WarnPercent,
 	})
+	task.SetDefaultRequeueLimit(c.Requeue.InitLimit)
 	// Register task engines
 	task.RegisterEngine(task.PodEngine, custom.NewPod, custom.InitK8sExtension, flow.NewPoller)
 	task.RegisterEngine(task.CrdEngine, custom.NewCrd, custom.InitK8sExtension, flow.NewPoller)
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"taskd/dao"
+	"taskd/internal/flow"
+	"taskd/internal/task"
+	"taskd/internal/utils"
+
+	"github.com/google/uuid"
+)
+
+/**
+ * Max tasks created by one batch
+ */
+const maxBatchTasks = 1000
+
+/**
+ * Parameters for batches API
+ * Tasks are listed one by one in Tasks, or expanded from Task with each combination of Matrix
+ */
+type BatchArgs struct {
+	ID     string           `json:"id,omitempty"`     // Batch ID, generated if empty
+	Tasks  []dao.TaskObjRec `json:"tasks,omitempty"`  // Tasks of the batch
+	Task   *dao.TaskObjRec  `json:"task,omitempty"`   // Task object shared by all tasks expanded from Matrix
+	Matrix map[string][]any `json:"matrix,omitempty"` // Values of args, one task is created for each combination
+}
+
+/**
+ * Result of creating a batch
+ */
+type BatchCommitResult struct {
+	ID     string   `json:"id"`               // Batch ID
+	UUIDs  []string `json:"uuids"`            // Tasks created, in submission order
+	Errors []string `json:"errors,omitempty"` // Tasks failed to create
+}
+
+/**
+ * Result of batches/{id} API
+ */
+type BatchStatusResult struct {
+	ID       string         `json:"id"`       // Batch ID
+	Total    int            `json:"total"`    // Number of tasks in the batch
+	Finished bool           `json:"finished"` // All tasks are finished
+	Counts   map[string]int `json:"counts"`   // Number of tasks in each status
+}
+
+/**
+ * Result of cancelling a batch
+ */
+type BatchCancelResult struct {
+	ID        string `json:"id"`        // Batch ID
+	Cancelled int    `json:"cancelled"` // Number of unfinished tasks cancelled
+}
+
+/**
+ * Expand task objects of the batch
+ */
+func (args *BatchArgs) expand() ([]dao.TaskObjRec, error) {
+	if len(args.Tasks) > 0 && args.Task != nil {
+		return nil, fmt.Errorf("either tasks or task with matrix should be specified")
+	}
+	tasks := args.Tasks
+	if args.Task != nil {
+		var err error
+		if tasks, err = expandMatrix(args.Task, args.Matrix); err != nil {
+			return nil, err
+		}
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("batch has no task")
+	}
+	if len(tasks) > maxBatchTasks {
+		return nil, fmt.Errorf("batch has %d tasks, exceeds the limit %d", len(tasks), maxBatchTasks)
+	}
+	for i := range tasks {
+		if tasks[i].Template == "" {
+			return nil, fmt.Errorf("task template of tasks[%d] cannot be empty", i)
+		}
+		tasks[i].Parent = args.ID
+	}
+	return tasks, nil
+}
+
+/**
+ * Create a task object for each combination of args values in matrix
+ * Values in matrix override the same-named fields in base.Args
+ */
+func expandMatrix(base *dao.TaskObjRec, matrix map[string][]any) ([]dao.TaskObjRec, error) {
+	baseArgs := make(map[string]any)
+	if base.Args != "" {
+		var err error
+		if baseArgs, err = task.ParseArgs(base.Args); err != nil {
+			return nil, fmt.Errorf("invalid args of task: %v", err)
+		}
+	}
+	keys := make([]string, 0, len(matrix))
+	count := 1
+	for k, values := range matrix {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("matrix [%s] has no value", k)
+		}
+		keys = append(keys, k)
+		count *= len(values)
+		if count > maxBatchTasks {
+			return nil, fmt.Errorf("matrix expands to more than %d tasks", maxBatchTasks)
+		}
+	}
+	sort.Strings(keys)
+
+	tasks := make([]dao.TaskObjRec, 0, count)
+	for i := 0; i < count; i++ {
+		args := make(map[string]any, len(baseArgs)+len(keys))
+		for k, v := range baseArgs {
+			args[k] = v
+		}
+		// The last key changes fastest
+		n := i
+		for j := len(keys) - 1; j >= 0; j-- {
+			values := matrix[keys[j]]
+			args[keys[j]] = values[n%len(values)]
+			n /= len(values)
+		}
+		data, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		to := *base
+		to.UUID = ""
+		to.Args = string(data)
+		tasks = append(tasks, to)
+	}
+	return tasks, nil
+}
+
+/**
+ * Submit a batch of tasks
+ * Tasks are created in order, so a task may depend on tasks listed before it
+ */
+func BatchCommit(args *BatchArgs) (BatchCommitResult, error) {
+	if args.ID == "" {
+		args.ID = uuid.New().String()
+	} else {
+		tasks, err := dao.LoadBatchTasks(args.ID)
+		if err != nil {
+			return BatchCommitResult{}, utils.RethrowError(http.StatusInternalServerError, err)
+		}
+		if len(tasks) > 0 {
+			return BatchCommitResult{}, utils.NewHttpError(http.StatusBadRequest,
+				fmt.Sprintf("Batch [%s] already exists", args.ID))
+		}
+	}
+	tasks, err := args.expand()
+	if err != nil {
+		return BatchCommitResult{}, utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	result := BatchCommitResult{
+		ID:    args.ID,
+		UUIDs: make([]string, 0, len(tasks)),
+	}
+	for i := range tasks {
+		r, err := TaskCommit(&tasks[i])
+		if err != nil {
+			utils.Errorf("Batch [%s] tasks[%d] commit failed: %v", args.ID, i, err)
+			result.Errors = append(result.Errors, fmt.Sprintf("tasks[%d]: %v", i, err))
+			continue
+		}
+		result.UUIDs = append(result.UUIDs, r.UUID)
+	}
+	return result, nil
+}
+
+/**
+ * Aggregated status of a batch
+ */
+func BatchStatus(id string) (BatchStatusResult, error) {
+	tasks, err := dao.LoadBatchTasks(id)
+	if err != nil {
+		return BatchStatusResult{}, utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	if len(tasks) == 0 {
+		return BatchStatusResult{}, utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("batch [%s] is not exist", id))
+	}
+	result := BatchStatusResult{
+		ID:       id,
+		Total:    len(tasks),
+		Finished: true,
+		Counts:   make(map[string]int),
+	}
+	for _, tr := range tasks {
+		result.Counts[tr.Status]++
+		if !task.TaskStatus(tr.Status).IsFinished() {
+			result.Finished = false
+		}
+	}
+	return result, nil
+}
+
+/**
+ * Cancel all unfinished tasks of a batch
+ */
+func BatchCancel(id string) (BatchCancelResult, error) {
+	tasks, err := dao.LoadBatchTasks(id)
+	if err != nil {
+		return BatchCancelResult{}, utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	if len(tasks) == 0 {
+		return BatchCancelResult{}, utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("batch [%s] is not exist", id))
+	}
+	result := BatchCancelResult{ID: id}
+	for _, tr := range tasks {
+		if task.TaskStatus(tr.Status).IsFinished() {
+			continue
+		}
+		if err := flow.CancelJob(tr.UUID); err != nil {
+			utils.Errorf("Batch [%s] cancel task [%s] failed: %v", id, tr.UUID, err)
+			continue
+		}
+		result.Cancelled++
+	}
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"reflect"
+	"taskd/dao"
+	"testing"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	base := &dao.TaskObjRec{UUID: "base", Template: "transform", Args: `{"model": "bert", "epochs": 1}`}
+	tasks, err := expandMatrix(base, map[string][]any{
+		"lr":     {0.1, 0.01},
+		"epochs": {1, 2, 3},
+	})
+	if err != nil {
+		t.Fatalf("expandMatrix() error = %v", err)
+	}
+	want := []string{
+		`{"epochs":1,"lr":0.1,"model":"bert"}`,
+		`{"epochs":1,"lr":0.01,"model":"bert"}`,
+		`{"epochs":2,"lr":0.1,"model":"bert"}`,
+		`{"epochs":2,"lr":0.01,"model":"bert"}`,
+		`{"epochs":3,"lr":0.1,"model":"bert"}`,
+		`{"epochs":3,"lr":0.01,"model":"bert"}`,
+	}
+	var got []string
+	for _, to := range tasks {
+		if to.UUID != "" || to.Template != "transform" {
+			t.Errorf("expandMatrix() task = %+v", to)
+		}
+		got = append(got, to.Args)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expandMatrix() args = %v, want %v", got, want)
+	}
+
+	if _, err := expandMatrix(base, map[string][]any{"lr": {}}); err == nil {
+		t.Errorf("expandMatrix() with empty values should fail")
+	}
+}
+
+func TestBatchArgs_Expand(t *testing.T) {
+	args := &BatchArgs{ID: "batch", Tasks: []dao.TaskObjRec{{Template: "a"}, {Template: "b"}}}
+	tasks, err := args.expand()
+	if err != nil {
+		t.Fatalf("expand() error = %v", err)
+	}
+	for _, to := range tasks {
+		if to.Parent != "batch" {
+			t.Errorf("expand() parent = %s, want batch", to.Parent)
+		}
+	}
+	args.Task = &dao.TaskObjRec{Template: "c"}
+	if _, err := args.expand(); err == nil {
+		t.Errorf("expand() with both tasks and task should fail")
+	}
+	if _, err := (&BatchArgs{Tasks: []dao.TaskObjRec{{}}}).expand(); err == nil {
+		t.Errorf("expand() without template should fail")
+	}
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"taskd/dao"
+	"taskd/internal/task"
+	"taskd/internal/utils"
+	"time"
+)
+
+/**
+ * Concurrency policy of a schedule, applied when the task of the previous run is still unfinished
+ */
+const (
+	ConcurrencyAllow   = "allow"   // Submit a new task anyway
+	ConcurrencyForbid  = "forbid"  // Skip this run
+	ConcurrencyReplace = "replace" // Cancel unfinished tasks, then submit a new task
+)
+
+/**
+ * Interval of checking due schedules
+ */
+const scheduleTick = 10 * time.Second
+
+/**
+ * Max runs returned by schedules/{name}/runs API
+ */
+const maxScheduleRuns = 100
+
+/**
+ * Parameters for updating a schedule
+ */
+type ScheduleUpdateArgs struct {
+	Cron        string `json:"cron,omitempty"`        // Cron expression
+	Task        string `json:"task,omitempty"`        // Task object submitted on each run (JSON)
+	Concurrency string `json:"concurrency,omitempty"` // Concurrency policy: allow, forbid or replace
+	Paused      *bool  `json:"paused,omitempty"`      // Pause or resume the schedule
+}
+
+/**
+ * Parameters for schedules/{name}/runs API
+ */
+type ScheduleRunsArgs struct {
+	Limit int `form:"limit,omitempty"` // Number of latest runs, default 20
+}
+
+/**
+ * A run of a schedule with the status of its task
+ */
+type ScheduleRunResult struct {
+	dao.ScheduleRunRec
+	Status string `json:"status,omitempty"` // Current status of the task submitted by the run
+}
+
+/**
+ * Serializes schedule runs with schedule updates
+ */
+var scheduleMutex sync.Mutex
+
+/**
+ * Check a schedule definition
+ */
+func checkSchedule(s *dao.ScheduleRec) (*utils.Cron, error) {
+	cron, err := utils.ParseCron(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	switch s.Concurrency {
+	case "", ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+	default:
+		return nil, fmt.Errorf("concurrency must be '%s', '%s' or '%s'", ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace)
+	}
+	var to dao.TaskObjRec
+	if err := json.Unmarshal([]byte(s.Task), &to); err != nil {
+		return nil, fmt.Errorf("invalid task of schedule: %v", err)
+	}
+	if to.Template == "" {
+		return nil, fmt.Errorf("task template of schedule cannot be empty")
+	}
+	return cron, nil
+}
+
+/**
+ * Set next run time of the schedule after t
+ */
+func setNextRunTime(s *dao.ScheduleRec, cron *utils.Cron, t time.Time) {
+	if next := cron.Next(t); next.IsZero() {
+		s.NextRunTime = nil
+	} else {
+		s.NextRunTime = &next
+	}
+}
+
+/**
+ * Define a schedule
+ */
+func AddSchedule(s *dao.ScheduleRec) error {
+	if s.Name == "" {
+		return utils.NewHttpError(http.StatusBadRequest, "schedule name cannot be empty")
+	}
+	cron, err := checkSchedule(s)
+	if err != nil {
+		return utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	now := time.Now().Local()
+	s.CreateTime = now
+	s.LastRunTime = nil
+	setNextRunTime(s, cron, now)
+	if err := s.Store(); err != nil {
+		utils.Errorf("Schedule [%s] store failed: %v", s.Name, err)
+		return utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
+/**
+ * Update a schedule
+ */
+func UpdateSchedule(name string, req *ScheduleUpdateArgs) error {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	s, err := dao.LoadSchedule(name)
+	if err != nil {
+		return utils.RethrowError(http.StatusBadRequest, err)
+	}
+	if req.Cron != "" {
+		s.Cron = req.Cron
+	}
+	if req.Task != "" {
+		s.Task = req.Task
+	}
+	if req.Concurrency != "" {
+		s.Concurrency = req.Concurrency
+	}
+	if req.Paused != nil {
+		s.Paused = *req.Paused
+	}
+	cron, err := checkSchedule(s)
+	if err != nil {
+		return utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	setNextRunTime(s, cron, time.Now().Local())
+	return s.Update()
+}
+
+/**
+ * Delete a schedule and its run history
+ * Tasks already submitted are not affected
+ */
+func DeleteSchedule(name string) error {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	s, err := dao.LoadSchedule(name)
+	if err != nil {
+		return utils.RethrowError(http.StatusBadRequest, err)
+	}
+	if err := s.Delete(); err != nil {
+		return utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
+/**
+ * Latest runs of a schedule, newest first
+ */
+func ListScheduleRuns(name string, args *ScheduleRunsArgs) ([]ScheduleRunResult, error) {
+	if _, err := dao.LoadSchedule(name); err != nil {
+		return nil, utils.RethrowError(http.StatusBadRequest, err)
+	}
+	limit := args.Limit
+	if limit <= 0 {
+		limit = 20
+	} else if limit > maxScheduleRuns {
+		limit = maxScheduleRuns
+	}
+	runs, err := dao.ListScheduleRuns(name, limit)
+	if err != nil {
+		return nil, utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	results := make([]ScheduleRunResult, 0, len(runs))
+	for _, run := range runs {
+		result := ScheduleRunResult{ScheduleRunRec: run}
+		if run.UUID != "" {
+			if tr, err := dao.LoadTask(run.UUID); err == nil {
+				result.Status = tr.Status
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+/**
+ * Submit tasks of due schedules, runs as a goroutine
+ */
+func RunSchedules() {
+	for {
+		<-time.After(scheduleTick)
+		runDueSchedules(time.Now().Local())
+	}
+}
+
+/**
+ * Run all schedules whose next run time has come
+ * Runs missed while taskd was down are merged into one run
+ */
+func runDueSchedules(now time.Time) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	schedules, err := dao.ListSchedules()
+	if err != nil {
+		utils.Errorf("List schedules failed: %v", err)
+		return
+	}
+	for i := range schedules {
+		s := &schedules[i]
+		if s.Paused || s.NextRunTime == nil || s.NextRunTime.After(now) {
+			continue
+		}
+		runSchedule(s, now)
+	}
+}
+
+/**
+ * Unfinished tasks submitted by recent runs of the schedule
+ */
+func activeScheduleTasks(name string) []string {
+	runs, err := dao.ListScheduleRuns(name, maxScheduleRuns)
+	if err != nil {
+		utils.Errorf("Schedule [%s] list runs failed: %v", name, err)
+		return nil
+	}
+	var active []string
+	for _, run := range runs {
+		if run.UUID == "" {
+			continue
+		}
+		tr, err := dao.LoadTask(run.UUID)
+		if err != nil || task.TaskStatus(tr.Status).IsFinished() {
+			continue
+		}
+		active = append(active, run.UUID)
+	}
+	return active
+}
+
+/**
+ * Run a schedule once according to its concurrency policy
+ */
+func runSchedule(s *dao.ScheduleRec, now time.Time) {
+	cron, err := utils.ParseCron(s.Cron)
+	if err != nil {
+		utils.Errorf("Schedule [%s] is ignored: %v", s.Name, err)
+		return
+	}
+	run := dao.ScheduleRunRec{
+		Schedule: s.Name,
+		RunTime:  *s.NextRunTime,
+	}
+	active := activeScheduleTasks(s.Name)
+	if len(active) > 0 && s.Concurrency == ConcurrencyForbid {
+		run.Error = fmt.Sprintf("skipped, previous tasks [%s] are unfinished", strings.Join(active, ","))
+	} else {
+		if s.Concurrency == ConcurrencyReplace {
+			for _, uuid := range active {
+				if err := TaskStop(uuid); err != nil {
+					utils.Errorf("Schedule [%s] cancel task [%s] failed: %v", s.Name, uuid, err)
+				}
+			}
+		}
+		if uuid, err := submitScheduleTask(s); err != nil {
+			run.Error = err.Error()
+		} else {
+			run.UUID = uuid
+		}
+	}
+	if run.Error != "" {
+		utils.Errorf("Schedule [%s] run at %v: %s", s.Name, run.RunTime, run.Error)
+	} else {
+		utils.Infof("Schedule [%s] run at %v submitted task [%s]", s.Name, run.RunTime, run.UUID)
+	}
+	if err := run.Store(); err != nil {
+		utils.Errorf("Schedule [%s] store run failed: %v", s.Name, err)
+	}
+	s.LastRunTime = &now
+	setNextRunTime(s, cron, now)
+	if err := s.Update(); err != nil {
+		utils.Errorf("Schedule [%s] update failed: %v", s.Name, err)
+	}
+}
+
+/**
+ * Submit the task of a schedule
+ */
+func submitScheduleTask(s *dao.ScheduleRec) (string, error) {
+	var to dao.TaskObjRec
+	if err := json.Unmarshal([]byte(s.Task), &to); err != nil {
+		return "", fmt.Errorf("invalid task of schedule: %v", err)
+	}
+	to.UUID = ""
+	if to.Name == "" {
+		to.Name = s.Name
+	}
+	if to.CreatedBy == "" {
+		to.CreatedBy = s.CreatedBy
+	}
+	result, err := TaskCommit(&to)
+	if err != nil {
+		return "", err
+	}
+	return result.UUID, nil
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"taskd/dao"
+	"testing"
+)
+
+func TestCheckSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       dao.ScheduleRec
+		wantErr bool
+	}{
+		{"valid", dao.ScheduleRec{Cron: "0 2 * * *", Task: `{"template": "evaluate"}`, Concurrency: ConcurrencyForbid}, false},
+		{"default concurrency", dao.ScheduleRec{Cron: "@daily", Task: `{"template": "evaluate"}`}, false},
+		{"invalid cron", dao.ScheduleRec{Cron: "0 25 * * *", Task: `{"template": "evaluate"}`}, true},
+		{"invalid concurrency", dao.ScheduleRec{Cron: "@daily", Task: `{"template": "evaluate"}`, Concurrency: "queue"}, true},
+		{"invalid task", dao.ScheduleRec{Cron: "@daily", Task: `{"template": `}, true},
+		{"no template", dao.ScheduleRec{Cron: "@daily", Task: `{"name": "evaluate"}`}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := checkSchedule(&tt.s); (err != nil) != tt.wantErr {
+				t.Errorf("checkSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
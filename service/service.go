@@ -1,6 +1,8 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -21,16 +23,44 @@ import (
  * Result of Commit API
  */
 type TaskCommitResult struct {
-	UUID string `json:"uuid"`
+	UUID     string `json:"uuid"`
+	Existing bool   `json:"existing,omitempty"` // An unfinished task with the same idempotency key is returned
+}
+
+/**
+ * Request parameters for tasks/{uuid}/rerun API, fields left empty are copied from the original task
+ */
+type TaskRerunArgs struct {
+	Args    string `json:"args,omitempty"`    // User arguments for task (JSON)
+	Extra   string `json:"extra,omitempty"`   // Extra info for template (JSON)
+	Pool    string `json:"pool,omitempty"`    // Task pool
+	Timeout string `json:"timeout,omitempty"` // Timeout settings (JSON)
+}
+
+/**
+ * Request parameters for tasks/{uuid}/move API
+ */
+type TaskMoveArgs struct {
+	Pool string `json:"pool"` // Target pool
 }
 
 /**
  * Result of tasks/{uuid}/status API
  */
 type TaskStatusResult struct {
-	Name     string `json:"name,omitempty"`
-	Template string `json:"template,omitempty"`
-	Status   string `json:"status,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Template  string         `json:"template,omitempty"`
+	Status    string         `json:"status,omitempty"`
+	LastEvent *dao.TaskEvent `json:"last_event,omitempty"` // Latest status transition
+}
+
+/**
+ * Result of tasks/{uuid}/events API
+ */
+type TaskEventsResult struct {
+	UUID   string          `json:"uuid"`
+	Status string          `json:"status"`
+	Events []dao.TaskEvent `json:"events"` // Status transitions, oldest first
 }
 
 /**
@@ -59,6 +89,30 @@ type TaskTagsResult struct {
 	Tags map[string]string `json:"tags"`
 }
 
+/**
+ * Task node of a task graph
+ */
+type TaskGraphNode struct {
+	UUID      string   `json:"uuid"`                 // Task UUID
+	Name      string   `json:"name,omitempty"`       // Task name
+	Template  string   `json:"template,omitempty"`   // Template name
+	Status    string   `json:"status"`               // Task status
+	DependsOn []string `json:"depends_on,omitempty"` // Upstream tasks
+}
+
+/**
+ * Result of tasks/{uuid}/graph API
+ */
+type TaskGraphResult struct {
+	UUID  string          `json:"uuid"`  // Task queried
+	Nodes []TaskGraphNode `json:"nodes"` // All tasks connected to the queried task
+}
+
+/**
+ * Max tasks returned by tasks/{uuid}/graph API
+ */
+const maxGraphNodes = 1000
+
 /**
  * Parameters for creating task pool
  */
@@ -131,6 +185,12 @@ func DeleteTemplate(name string) error {
  * Add a task pool with associated resources
  */
 func AddPool(arg *TaskPoolArgs) error {
+	if _, err := task.ParsePoolPolicy(arg.Policy); err != nil {
+		return utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	if _, err := task.ParseLabels(arg.Labels); err != nil {
+		return utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
 	err := dao.DB.Transaction(func(tx *gorm.DB) error {
 		exists, err := arg.Exists(tx)
 		if err != nil {
@@ -158,6 +218,7 @@ func AddPool(arg *TaskPoolArgs) error {
 
 /**
  * Update task pool definition
+ * Changes are saved before being applied to the live pool, an update the live pool rejects is reverted
  */
 func UpdatePool(req *TaskPoolArgs) error {
 	var pool *dao.Pool
@@ -165,21 +226,134 @@ func UpdatePool(req *TaskPoolArgs) error {
 	if pool, err = dao.LoadPool(req.PoolId); err != nil {
 		return err
 	}
+	original := *pool
 	if req.Engine != "" {
 		pool.Engine = req.Engine
 	}
 	if req.Config != "" {
 		pool.Config = req.Config
 	}
-	if req.Engine != "" {
-		pool.Engine = req.Engine
+	if req.Description != "" {
+		pool.Description = req.Description
+	}
+	if req.Policy != "" {
+		if _, err := task.ParsePoolPolicy(req.Policy); err != nil {
+			return utils.NewHttpError(http.StatusBadRequest, err.Error())
+		}
+		pool.Policy = req.Policy
+	}
+	if req.Labels != "" {
+		if _, err := task.ParseLabels(req.Labels); err != nil {
+			return utils.NewHttpError(http.StatusBadRequest, err.Error())
+		}
+		pool.Labels = req.Labels
+	}
+	if req.Running > 0 {
+		pool.Running = req.Running
+	}
+	if req.Waiting > 0 {
+		pool.Waiting = req.Waiting
 	}
-	pool.Running = req.Waiting
-	pool.Waiting = req.Waiting
 	if err = pool.Update(dao.DB); err != nil {
 		return err
 	}
-	flow.ReloadPoolConfigs(pool.PoolId)
+	if err = flow.ReconfigurePool(*pool); err != nil {
+		if err := original.Revert(dao.DB); err != nil {
+			utils.Errorf("Pool [%s] revert rejected update failed: %v", pool.PoolId, err)
+		}
+		return utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+/**
+ * Pause a task pool: submissions are still accepted, but no waiting task is started
+ */
+func PausePool(poolId string) error {
+	return setPoolState(poolId, task.PoolStatePaused)
+}
+
+/**
+ * Drain a task pool: submissions are rejected, waiting tasks keep starting until the pool is empty
+ */
+func DrainPool(poolId string) error {
+	return setPoolState(poolId, task.PoolStateDraining)
+}
+
+/**
+ * Resume a paused or draining task pool
+ */
+func ResumePool(poolId string) error {
+	return setPoolState(poolId, task.PoolStateActive)
+}
+
+/**
+ * Change and save state of a task pool
+ */
+func setPoolState(poolId string, state string) error {
+	tp := flow.GetPool(poolId)
+	if tp == nil {
+		return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("pool [%s] is not exist", poolId))
+	}
+	pool := dao.Pool{PoolId: poolId, State: state}
+	if err := pool.UpdateState(dao.DB); err != nil {
+		return utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	if err := tp.SetState(state); err != nil {
+		return utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	utils.Infof("Pool [%s] is %s", poolId, state)
+	if tp.IsStartable() {
+		tp.SendRunningChan(1)
+	}
+	return nil
+}
+
+/**
+ * Concurrency limits of a task pool with their current usage
+ */
+func GetPoolLimits(poolId string) ([]task.LimitUsage, error) {
+	tp := flow.GetPool(poolId)
+	if tp == nil {
+		return nil, utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("pool [%s] is not exist", poolId))
+	}
+	return tp.GetLimitUsages(), nil
+}
+
+/**
+ * Replace concurrency limits of a task pool
+ * Limits are saved in the pool policy and take effect immediately
+ */
+func SetPoolLimits(poolId string, limits []task.ConcurrencyLimit) error {
+	tp := flow.GetPool(poolId)
+	if tp == nil {
+		return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("pool [%s] is not exist", poolId))
+	}
+	pool, err := dao.LoadPool(poolId)
+	if err != nil {
+		return utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	policy, err := task.ParsePoolPolicy(pool.Policy)
+	if err != nil {
+		return utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	policy.Limits = limits
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	if _, err := task.ParsePoolPolicy(string(data)); err != nil {
+		return utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	pool.Policy = string(data)
+	if err := pool.Update(dao.DB); err != nil {
+		return utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	// Jobs skipped by the former limits may start now
+	if err := tp.SetPolicy(pool.Policy); err != nil {
+		return utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	tp.SendRunningChan(1)
 	return nil
 }
 
@@ -187,6 +361,22 @@ func UpdatePool(req *TaskPoolArgs) error {
  * Submit a new task
  */
 func TaskCommit(to *dao.TaskObjRec) (TaskCommitResult, error) {
+	if _, err := task.ParseRetryPolicy(to.Retry); err != nil {
+		return TaskCommitResult{}, utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	if err := checkDependencies(to); err != nil {
+		return TaskCommitResult{}, err
+	}
+	if err := task.CheckPoolSelector(to); err != nil {
+		return TaskCommitResult{}, utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	if to.Delay < 0 {
+		return TaskCommitResult{}, utils.NewHttpError(http.StatusBadRequest, "delay cannot be negative")
+	}
+	if tp := flow.GetPool(to.Pool); tp != nil && !tp.IsAccepting() {
+		return TaskCommitResult{}, utils.NewHttpError(http.StatusServiceUnavailable,
+			fmt.Sprintf("pool [%s] is draining, no task can be submitted", to.Pool))
+	}
 	if to.UUID == "" {
 		to.UUID = uuid.New().String()
 	} else {
@@ -199,21 +389,51 @@ func TaskCommit(to *dao.TaskObjRec) (TaskCommitResult, error) {
 				fmt.Sprintf("Task [%s] already exists", to.UUID))
 		}
 	}
+	if err := setIdempotencyKey(to); err != nil {
+		return TaskCommitResult{}, utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	if to.IdempotencyKey != "" {
+		existing, err := claimIdempotencyKey(to)
+		if err != nil {
+			return TaskCommitResult{}, utils.RethrowError(http.StatusInternalServerError, err)
+		}
+		if existing != "" {
+			utils.Infof("Task [%s:%s] is already submitted with idempotency key [%s]", to.Template, existing, to.IdempotencyKey)
+			return TaskCommitResult{UUID: existing, Existing: true}, nil
+		}
+	}
 	now := time.Now().Local()
+	if to.StartAfter == nil && to.Delay > 0 {
+		startAfter := now.Add(time.Duration(to.Delay) * time.Second)
+		to.StartAfter = &startAfter
+	}
 	ti := dao.TaskRec{
 		TaskObjRec: *to,
 	}
 	ti.CreateTime = &now
+	ti.QueueTime = &now
 	ti.UpdateTime = &now
 	ti.Status = string(task.TaskStatusQueue)
 
 	if err := ti.Create(); err != nil {
 		utils.Errorf("Task [%s:%s] store failed: %v", ti.Template, ti.UUID, err)
+		releaseIdempotencyKey(to)
 		return TaskCommitResult{}, utils.RethrowError(http.StatusInternalServerError, err)
 	}
-	_, err := flow.PoolNewJob(&ti)
+	if to.IdempotencyKey != "" {
+		if err := dao.KeepIdempotencyKey(to.IdempotencyKey, to.UUID); err != nil {
+			utils.Errorf("Task [%s:%s] keep idempotency key failed: %v", ti.Template, ti.UUID, err)
+		}
+	}
+	_, err := flow.SubmitJob(&ti)
 	if err != nil {
 		utils.Errorf("Task [%s:%s] start failed: %v", ti.Template, ti.UUID, err)
+		// The task is not queued, so it must not be reloaded on restart
+		ti.Delete()
+		releaseIdempotencyKey(to)
+		if httpErr, ok := err.(*utils.HttpError); ok {
+			return TaskCommitResult{}, httpErr
+		}
 		return TaskCommitResult{}, utils.RethrowError(http.StatusExpectationFailed, err)
 	}
 
@@ -222,6 +442,131 @@ func TaskCommit(to *dao.TaskObjRec) (TaskCommitResult, error) {
 	}, nil
 }
 
+/**
+ * Use hash of template and args as idempotency key if dedup is requested
+ * Args are normalized, so the same args in different JSON layout have the same hash
+ */
+func setIdempotencyKey(to *dao.TaskObjRec) error {
+	if to.IdempotencyKey != "" || !to.Dedup {
+		return nil
+	}
+	args := make(map[string]any)
+	if to.Args != "" {
+		var err error
+		if args, err = task.ParseArgs(to.Args); err != nil {
+			return fmt.Errorf("invalid args: %v", err)
+		}
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(append([]byte(to.Template+"\n"), data...))
+	to.IdempotencyKey = hex.EncodeToString(sum[:])
+	return nil
+}
+
+/**
+ * Map the idempotency key to the task being submitted
+ * Returns UUID of the task already submitted with the key, empty if the key is claimed
+ * A task not stored yet is being submitted concurrently and counts as unfinished
+ */
+func claimIdempotencyKey(to *dao.TaskObjRec) (string, error) {
+	for {
+		owner, err := dao.ClaimIdempotencyKey(to.IdempotencyKey, to.UUID)
+		if err != nil || owner == "" {
+			return "", err
+		}
+		tr, err := dao.LoadTask(owner)
+		if err != nil {
+			return "", err
+		}
+		if tr.UUID == "" || !task.TaskStatus(tr.Status).IsFinished() {
+			return owner, nil
+		}
+		// The former task is finished, the key can be used again unless another submission took it over first
+		ok, err := dao.ReplaceIdempotencyKey(to.IdempotencyKey, owner, to.UUID)
+		if err != nil || ok {
+			return "", err
+		}
+	}
+}
+
+/**
+ * Release the idempotency key claimed by a task failed to submit
+ */
+func releaseIdempotencyKey(to *dao.TaskObjRec) {
+	if to.IdempotencyKey == "" {
+		return
+	}
+	if err := dao.DelIdempotencyKey(to.IdempotencyKey); err != nil {
+		utils.Errorf("Task [%s:%s] release idempotency key failed: %v", to.Template, to.UUID, err)
+	}
+}
+
+/**
+ * Check upstream tasks declared by depends_on
+ * Upstream tasks must exist already, so the dependencies can never form a cycle
+ */
+func checkDependencies(to *dao.TaskObjRec) error {
+	if err := task.CheckUpstreamFailure(to.OnUpstreamFailure); err != nil {
+		return utils.NewHttpError(http.StatusBadRequest, err.Error())
+	}
+	for _, upstream := range to.DependsOn {
+		if upstream == "" || upstream == to.UUID {
+			return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("invalid upstream task [%s]", upstream))
+		}
+		exist, err := dao.ExistTask(upstream)
+		if err != nil {
+			return utils.RethrowError(http.StatusInternalServerError, err)
+		}
+		if !exist {
+			return utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("upstream task [%s] is not exist", upstream))
+		}
+	}
+	return nil
+}
+
+/**
+ * Get the task graph (DAG) the task belongs to
+ * Walks both upstream (depends_on) and downstream (dependents) edges from the task
+ */
+func TaskGraph(uuid string) (*TaskGraphResult, error) {
+	result := &TaskGraphResult{UUID: uuid}
+	visited := map[string]bool{uuid: true}
+	queue := []string{uuid}
+	for len(queue) > 0 && len(result.Nodes) < maxGraphNodes {
+		current := queue[0]
+		queue = queue[1:]
+		tr, err := dao.LoadTask(current)
+		if err != nil {
+			if current == uuid {
+				return nil, utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("task [%s] is not exist", uuid))
+			}
+			utils.Errorf("Task [%s] load failed: %v", current, err)
+			continue
+		}
+		result.Nodes = append(result.Nodes, TaskGraphNode{
+			UUID:      tr.UUID,
+			Name:      tr.Name,
+			Template:  tr.Template,
+			Status:    tr.Status,
+			DependsOn: tr.DependsOn,
+		})
+		dependents, err := dao.ListDependents(current)
+		if err != nil {
+			return nil, utils.RethrowError(http.StatusInternalServerError, err)
+		}
+		for _, next := range append(tr.DependsOn, dependents...) {
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return result, nil
+}
+
 /**
  * Task status
  */
@@ -235,9 +580,34 @@ func TaskStatus(uuid string) (TaskStatusResult, error) {
 		Status:   string(to.Status),
 		Template: to.Template,
 	}
+	if n := len(to.Events); n > 0 {
+		result.LastEvent = &to.Events[n-1]
+	}
 	return result, err
 }
 
+/**
+ * Get status transitions of a task
+ */
+func TaskEvents(uuid string) (*TaskEventsResult, error) {
+	to, err := GetTask(uuid)
+	if err != nil {
+		return nil, utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	if to.UUID == "" {
+		return nil, utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("task [%s] is not exist", uuid))
+	}
+	result := &TaskEventsResult{
+		UUID:   to.UUID,
+		Status: to.Status,
+		Events: to.Events,
+	}
+	if result.Events == nil {
+		result.Events = []dao.TaskEvent{}
+	}
+	return result, nil
+}
+
 /*
  * Get task log stream
  * @param uuid Task ID
@@ -325,6 +695,84 @@ func TaskStop(uuid string) error {
 	return flow.CancelJob(uuid)
 }
 
+/**
+ * Suspend task instance, releasing its pool slot and quotas
+ */
+func TaskSuspend(uuid string) error {
+	return flow.SuspendJob(uuid)
+}
+
+/**
+ * Resume suspended task instance
+ */
+func TaskResume(uuid string) error {
+	return flow.ResumeJob(uuid)
+}
+
+/**
+ * Move a task that has not started to another pool
+ */
+func TaskMove(uuid string, args *TaskMoveArgs) error {
+	if args.Pool == "" {
+		return utils.NewHttpError(http.StatusBadRequest, "target pool cannot be empty")
+	}
+	return flow.MoveJob(uuid, args.Pool)
+}
+
+/**
+ * Create a new task from a finished task, with the overrides in args
+ * The new task links to the original by rerun_of, it leaves the batch of the original
+ * and is queued after its own delay again instead of the original start_after
+ */
+func TaskRerun(uuid string, args *TaskRerunArgs) (TaskCommitResult, error) {
+	rec, err := GetTask(uuid)
+	if err != nil {
+		return TaskCommitResult{}, utils.RethrowError(http.StatusInternalServerError, err)
+	}
+	if rec.UUID == "" {
+		return TaskCommitResult{}, utils.NewHttpError(http.StatusBadRequest, fmt.Sprintf("task [%s] is not exist", uuid))
+	}
+	if status := task.TaskStatus(rec.Status); !status.IsFinished() {
+		return TaskCommitResult{}, utils.NewHttpError(http.StatusBadRequest,
+			fmt.Sprintf("task [%s] is %s, only finished tasks can be rerun", uuid, status))
+	}
+	to := newRerun(rec, args)
+	result, err := TaskCommit(to)
+	if err == nil && !result.Existing {
+		utils.Infof("Task [%s:%s] is rerun as [%s]", rec.Template, rec.UUID, result.UUID)
+	}
+	return result, err
+}
+
+/**
+ * Task object of a rerun, copied from the original task with the overrides
+ * The rerun starts at once: upstream tasks of the original have finished already, so dependencies are dropped,
+ * and the idempotency key of the original is not taken over (dedup computes it again from the args)
+ */
+func newRerun(rec *dao.TaskRec, args *TaskRerunArgs) *dao.TaskObjRec {
+	to := rec.TaskObjRec
+	to.UUID = ""
+	to.RerunOf = rec.UUID
+	to.Parent = ""
+	to.StartAfter = nil
+	to.DependsOn = nil
+	to.OnUpstreamFailure = ""
+	to.IdempotencyKey = ""
+	if args.Args != "" {
+		to.Args = args.Args
+	}
+	if args.Extra != "" {
+		to.Extra = args.Extra
+	}
+	if args.Pool != "" {
+		to.Pool = args.Pool
+	}
+	if args.Timeout != "" {
+		to.Timeout = args.Timeout
+	}
+	return &to
+}
+
 /**
  * Tag task instance
  */
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"taskd/dao"
+	"taskd/internal/flow"
+	"taskd/internal/task"
+	"testing"
+	"time"
+
+	"github.com/agiledragon/gomonkey/v2"
+	"gorm.io/gorm"
+)
+
+func TestSetIdempotencyKey(t *testing.T) {
+	a := &dao.TaskObjRec{Template: "train", Args: `{"model": "bert", "epochs": 3}`, Dedup: true}
+	b := &dao.TaskObjRec{Template: "train", Args: `{"epochs":3,"model":"bert"}`, Dedup: true}
+	c := &dao.TaskObjRec{Template: "eval", Args: `{"epochs":3,"model":"bert"}`, Dedup: true}
+	for _, to := range []*dao.TaskObjRec{a, b, c} {
+		if err := setIdempotencyKey(to); err != nil {
+			t.Fatalf("setIdempotencyKey() error = %v", err)
+		}
+	}
+	if a.IdempotencyKey == "" || a.IdempotencyKey != b.IdempotencyKey {
+		t.Errorf("same template and args should have the same key: %s, %s", a.IdempotencyKey, b.IdempotencyKey)
+	}
+	if a.IdempotencyKey == c.IdempotencyKey {
+		t.Errorf("different templates should have different keys")
+	}
+
+	d := &dao.TaskObjRec{Template: "train", IdempotencyKey: "client-key", Dedup: true}
+	if err := setIdempotencyKey(d); err != nil || d.IdempotencyKey != "client-key" {
+		t.Errorf("setIdempotencyKey() should keep client key, got %s, %v", d.IdempotencyKey, err)
+	}
+	e := &dao.TaskObjRec{Template: "train"}
+	if err := setIdempotencyKey(e); err != nil || e.IdempotencyKey != "" {
+		t.Errorf("setIdempotencyKey() without dedup should not set key, got %s, %v", e.IdempotencyKey, err)
+	}
+}
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	// key -> owner, and the stored tasks
+	keys := map[string]string{}
+	tasks := map[string]string{"finished": string(task.TaskStatusSucceeded), "running": string(task.TaskStatusRunning)}
+	patches := gomonkey.ApplyFunc(dao.ClaimIdempotencyKey, func(key, uuid string) (string, error) {
+		if owner, ok := keys[key]; ok {
+			return owner, nil
+		}
+		keys[key] = uuid
+		return "", nil
+	})
+	defer patches.Reset()
+	patches.ApplyFunc(dao.LoadTask, func(uuid string) (*dao.TaskRec, error) {
+		tr := &dao.TaskRec{}
+		if status, ok := tasks[uuid]; ok {
+			tr.UUID, tr.Status = uuid, status
+		}
+		return tr, nil
+	})
+	var takenOver bool
+	patches.ApplyFunc(dao.ReplaceIdempotencyKey, func(key, former, uuid string) (bool, error) {
+		if takenOver {
+			// Another submission replaced the finished task first
+			keys[key] = "other"
+			return false, nil
+		}
+		if keys[key] != former {
+			return false, nil
+		}
+		keys[key] = uuid
+		return true, nil
+	})
+
+	tests := []struct {
+		name       string
+		owner      string
+		takenOver  bool
+		wantExists string
+		wantOwner  string
+	}{
+		{"new key", "", false, "", "new"},
+		{"owner running", "running", false, "running", "running"},
+		{"owner not stored yet", "submitting", false, "submitting", "submitting"},
+		{"owner finished", "finished", false, "", "new"},
+		{"owner finished and taken over", "finished", true, "other", "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delete(keys, "key")
+			if tt.owner != "" {
+				keys["key"] = tt.owner
+			}
+			takenOver = tt.takenOver
+			existing, err := claimIdempotencyKey(&dao.TaskObjRec{UUID: "new", IdempotencyKey: "key"})
+			if err != nil || existing != tt.wantExists {
+				t.Errorf("claimIdempotencyKey() = %q, %v, want %q", existing, err, tt.wantExists)
+			}
+			if keys["key"] != tt.wantOwner {
+				t.Errorf("key owner = %q, want %q", keys["key"], tt.wantOwner)
+			}
+		})
+	}
+}
+
+func TestUpdatePool(t *testing.T) {
+	var calls []string
+	var rejected error
+	patches := gomonkey.ApplyFunc(dao.LoadPool, func(poolId string) (*dao.Pool, error) {
+		return &dao.Pool{PoolId: poolId, Engine: "k8s", Running: 2, Waiting: 10}, nil
+	})
+	defer patches.Reset()
+	patches.ApplyMethod(reflect.TypeOf(&dao.Pool{}), "Update", func(p *dao.Pool, _ *gorm.DB) error {
+		calls = append(calls, "update")
+		return nil
+	})
+	patches.ApplyMethod(reflect.TypeOf(&dao.Pool{}), "Revert", func(p *dao.Pool, _ *gorm.DB) error {
+		if p.Running != 2 {
+			t.Errorf("reverted to running = %d, want the original 2", p.Running)
+		}
+		calls = append(calls, "revert")
+		return nil
+	})
+	patches.ApplyFunc(flow.ReconfigurePool, func(dao.Pool) error {
+		calls = append(calls, "reconfigure")
+		return rejected
+	})
+
+	req := &TaskPoolArgs{Pool: dao.Pool{PoolId: "test", Running: 4}}
+	if err := UpdatePool(req); err != nil || strings.Join(calls, ",") != "update,reconfigure" {
+		t.Errorf("UpdatePool() = %v, calls = %v, want saved before reconfigured", err, calls)
+	}
+
+	calls, rejected = nil, errors.New("invalid config")
+	if err := UpdatePool(req); err == nil || strings.Join(calls, ",") != "update,reconfigure,revert" {
+		t.Errorf("UpdatePool() = %v, calls = %v, want rejected update reverted", err, calls)
+	}
+}
+
+func TestNewRerun(t *testing.T) {
+	startAfter := time.Now().Add(-time.Hour)
+	rec := &dao.TaskRec{
+		TaskObjRec: dao.TaskObjRec{
+			UUID:       "original",
+			Parent:     "batch",
+			Template:   "train",
+			Pool:       "gpu-pool-1",
+			Args:       `{"epochs": 3}`,
+			Extra:      `{"image": "torch"}`,
+			Timeout:    `{"running": 60}`,
+			StartAfter: &startAfter,
+			Delay:      30,
+
+			DependsOn:         []string{"upstream"},
+			OnUpstreamFailure: "skip",
+			IdempotencyKey:    "client-key",
+		},
+	}
+	rec.Status = "Failed"
+	rec.Error = "exit code 1"
+
+	to := newRerun(rec, &TaskRerunArgs{Args: `{"epochs": 5}`, Pool: "gpu-pool-2"})
+	if to.UUID != "" || to.RerunOf != "original" || to.Parent != "" || to.StartAfter != nil || to.Delay != 30 {
+		t.Errorf("newRerun() = %+v, want a new task linked to the original", to)
+	}
+	if to.DependsOn != nil || to.OnUpstreamFailure != "" || to.IdempotencyKey != "" {
+		t.Errorf("newRerun() = %+v, want dependencies and idempotency key dropped", to)
+	}
+	if to.Template != "train" || to.Args != `{"epochs": 5}` || to.Pool != "gpu-pool-2" ||
+		to.Extra != `{"image": "torch"}` || to.Timeout != `{"running": 60}` {
+		t.Errorf("newRerun() = %+v, want overrides applied on the original", to)
+	}
+	if rec.UUID != "original" || rec.Args != `{"epochs": 3}` || len(rec.DependsOn) != 1 || rec.IdempotencyKey != "client-key" {
+		t.Errorf("original task is modified: %+v", rec.TaskObjRec)
+	}
+}